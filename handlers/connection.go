@@ -1,124 +1,205 @@
-// SERVER
-
 package handlers
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/joho/godotenv"
+	"io/fs"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
 )
 
 var (
+	// Dialect is the SQL dialect of CloudSQLDB, DialectMySQL or DialectSQLite
+	Dialect = DialectMySQL
+
 	CloudSQLDB *sql.DB
 	QuestionDB *sql.DB
 	TestDB     *sql.DB
 	UserDataDB *sql.DB
 )
 
-// ConnectToDB establishes a connection to the specified Cloud SQL database
+// Connection modes selected with DB_MODE
+const (
+	ModeCloudSQL = "cloudsql" // Cloud SQL Unix socket under /cloudsql
+	ModeTCP      = "tcp"      // plain TCP, e.g. a local MySQL
+	ModeTLS      = "tls"      // TCP with TLS
+	ModeSQLite   = "sqlite"   // embedded SQLite file, no server needed
+)
+
+// SQL dialects
+const (
+	DialectMySQL  = "mysql"
+	DialectSQLite = "sqlite"
+)
+
+// LoadEnv loads environment variables from a .env file when there is one.
+// Variables already set in the environment take precedence.
+func LoadEnv() {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+}
+
+// dbMode returns DB_MODE, defaulting to Cloud SQL when an instance
+// connection name is configured and to plain TCP otherwise
+func dbMode() string {
+	if mode := os.Getenv("DB_MODE"); mode != "" {
+		return mode
+	}
+	if os.Getenv("INSTANCE_CONNECTION_NAME") != "" {
+		return ModeCloudSQL
+	}
+	return ModeTCP
+}
+
+// mysqlConfig builds the driver configuration for the selected mode
+func mysqlConfig(dbName string) (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = os.Getenv("DB_USER")
+	cfg.Passwd = os.Getenv("DB_PASSWORD")
+	cfg.DBName = dbName
+	cfg.ParseTime = true
+
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "3306"
+	}
+
+	switch mode := dbMode(); mode {
+	case ModeCloudSQL:
+		instance := os.Getenv("INSTANCE_CONNECTION_NAME")
+		if instance == "" {
+			return nil, errors.New("INSTANCE_CONNECTION_NAME is required for cloudsql mode")
+		}
+		cfg.Net = "unix"
+		cfg.Addr = "/cloudsql/" + instance
+	case ModeTCP:
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(host, port)
+	case ModeTLS:
+		tlsConfig, err := dbTLSConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(host, port)
+		cfg.TLS = tlsConfig
+	default:
+		return nil, fmt.Errorf("unknown DB_MODE %q (want %s, %s, %s or %s)", mode, ModeCloudSQL, ModeTCP, ModeTLS, ModeSQLite)
+	}
+	return cfg, nil
+}
+
+// dbTLSConfig verifies the server against DB_TLS_CA when set, otherwise the
+// system roots. DB_TLS_CERT and DB_TLS_KEY add a client certificate.
+func dbTLSConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if name := os.Getenv("DB_TLS_SERVER_NAME"); name != "" {
+		cfg.ServerName = name
+	}
+	if os.Getenv("DB_TLS_SKIP_VERIFY") == "true" {
+		cfg.InsecureSkipVerify = true
+	}
+
+	if caFile := os.Getenv("DB_TLS_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DB_TLS_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("DB_TLS_CERT"), os.Getenv("DB_TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ConnectToDB opens the named database using the connection mode from
+// DB_MODE. The database may still be starting, so pinging is retried with
+// exponential backoff up to DB_CONNECT_ATTEMPTS times (default 10).
+// In sqlite mode dbName is unused and the file comes from SQLITE_PATH.
 func ConnectToDB(dbName string) (*sql.DB, error) {
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	instanceConnectionName := os.Getenv("INSTANCE_CONNECTION_NAME")
+	if dbMode() == ModeSQLite {
+		return connectSQLite()
+	}
 
-	// Cloud SQL connection using Unix socket for production
-	dsn := fmt.Sprintf("%s:%s@unix(/cloudsql/%s)/%s?parseTime=true", dbUser, dbPassword, instanceConnectionName, dbName)
-	db, err := sql.Open("mysql", dsn)
+	cfg, err := mysqlConfig(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := mysql.NewConnector(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open connection: %w", err)
 	}
+	db := sql.OpenDB(connector)
+
+	attempts := 10
+	if n, err := strconv.Atoi(os.Getenv("DB_CONNECT_ATTEMPTS")); err == nil && n > 0 {
+		attempts = n
+	}
 
-	// Check connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= attempts {
+			break
+		}
+		fmt.Printf("Database %s over %s not reachable (attempt %d/%d), retrying in %s: %v\n", dbName, cfg.Net, attempt, attempts, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, 30*time.Second)
 	}
 
-	return db, nil
+	db.Close()
+	return nil, fmt.Errorf("failed to ping database after %d attempts: %w", attempts, err)
 }
 
 func InitDB() {
+	// Load environment variables
+	LoadEnv()
+
 	var err error
 
 	// Initialize Cloud SQL database
 	CloudSQLDB, err = ConnectToDB(os.Getenv("DB_NAME"))
 	if err != nil {
-		log.Fatalf("Failed to connect to Cloud SQL: %v", err)
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	if dbMode() == ModeSQLite {
+		// SQLite allows one writer at a time; a single connection
+		// serializes statements instead of contending for the lock
+		Dialect = DialectSQLite
+		CloudSQLDB.SetMaxOpenConns(1)
+		return
 	}
 	CloudSQLDB.SetMaxOpenConns(20)
 	CloudSQLDB.SetMaxIdleConns(10)
 
 }
-
-// local development
-
-// package handlers
-
-// import (
-// 	"database/sql"
-// 	"fmt"
-// 	"log"
-// 	"os"
-
-// 	_ "github.com/go-sql-driver/mysql"
-// 	"github.com/joho/godotenv"
-// )
-
-// var (
-// 	CloudSQLDB *sql.DB
-// 	QuestionDB *sql.DB
-// 	TestDB     *sql.DB
-// 	UserDataDB *sql.DB
-// )
-
-// // LoadEnv loads environment variables from the .env file
-// func LoadEnv() {
-// 	// Load the environment variables from .env file
-// 	err := godotenv.Load()
-// 	if err != nil {
-// 		log.Fatalf("Error loading .env file")
-// 	}
-// }
-
-// // ConnectToDB establishes a connection to the specified MySQL database
-// func ConnectToDB(dbName string) (*sql.DB, error) {
-// 	dbUser := os.Getenv("DB_USER")
-// 	dbPassword := os.Getenv("DB_PASSWORD")
-// 	dbHost := os.Getenv("DB_HOST")
-// 	dbPort := os.Getenv("DB_PORT")
-
-// 	// Connection using TCP (local development)
-// 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbPort, dbName)
-
-// 	db, err := sql.Open("mysql", dsn)
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to open connection: %w", err)
-// 	}
-
-// 	// Check connection
-// 	if err := db.Ping(); err != nil {
-// 		return nil, fmt.Errorf("failed to ping database: %w", err)
-// 	}
-
-// 	return db, nil
-// }
-
-// // InitDB initializes all database connections for local development
-// func InitDB() {
-// 	// Load environment variables
-// 	LoadEnv()
-
-// 	var err error
-
-// 	// Initialize Cloud SQL database
-// 	CloudSQLDB, err = ConnectToDB(os.Getenv("DB_NAME"))
-// 	if err != nil {
-// 		log.Fatalf("Failed to connect to Cloud SQL: %v", err)
-// 	}
-// 	CloudSQLDB.SetMaxOpenConns(20)
-// 	CloudSQLDB.SetMaxIdleConns(10)
-
-// }
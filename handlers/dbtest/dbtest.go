@@ -0,0 +1,45 @@
+// Package dbtest gives tests an in-memory SQLite database in place of the
+// configured one
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/migrations"
+)
+
+// Connect opens an empty in-memory SQLite database as handlers.CloudSQLDB,
+// restoring the previous database when the test ends
+func Connect(t testing.TB) *sql.DB {
+	t.Helper()
+	t.Setenv("DB_MODE", handlers.ModeSQLite)
+	t.Setenv("SQLITE_PATH", ":memory:")
+
+	db, err := handlers.ConnectToDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: has a database of its own
+	db.SetMaxOpenConns(1)
+
+	previous, dialect := handlers.CloudSQLDB, handlers.Dialect
+	handlers.CloudSQLDB, handlers.Dialect = db, handlers.DialectSQLite
+	t.Cleanup(func() {
+		handlers.CloudSQLDB, handlers.Dialect = previous, dialect
+		db.Close()
+	})
+	return db
+}
+
+// Open is Connect with every migration applied
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	db := Connect(t)
+	if _, err := migrations.Up(context.Background(), db, handlers.DialectSQLite); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,93 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published over the lifetime of a video
+const (
+	UploadReceived   = "upload.received"
+	StateChanged     = "state.changed"
+	EncodingStarted  = "encoding.started"
+	EncodingProgress = "encoding.progress"
+	SegmentUploaded  = "segment.uploaded"
+	VideoReady       = "video.ready"
+	VideoFailed      = "video.failed"
+)
+
+// Event is a change in a video's lifecycle
+type Event struct {
+	Type    string                 `json:"type"`
+	VideoID string                 `json:"video_id"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// Terminal reports whether no further events follow e for its video
+func (e Event) Terminal() bool {
+	return e.Type == VideoReady || e.Type == VideoFailed
+}
+
+// Subscribers that fall this far behind miss events instead of blocking publishers
+const bufferSize = 64
+
+var (
+	mu          sync.Mutex
+	subscribers = map[string]map[chan Event]struct{}{}
+	listeners   []func(Event)
+)
+
+// Listen registers fn to be called synchronously for every published event.
+// Unlike subscribers, listeners never miss an event, so fn must be quick.
+func Listen(fn func(Event)) {
+	mu.Lock()
+	defer mu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Publish delivers an event to this instance's listeners, its subscribers
+// of the video and those subscribed to every video
+func Publish(eventType, videoID string, data map[string]interface{}) {
+	e := Event{Type: eventType, VideoID: videoID, Data: data, Time: time.Now().UTC()}
+
+	mu.Lock()
+	fns := listeners
+	mu.Unlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range []string{videoID, ""} {
+		for ch := range subscribers[key] {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the video's events, or every video's
+// events when videoID is empty. Call the returned function to unsubscribe.
+func Subscribe(videoID string) (<-chan Event, func()) {
+	ch := make(chan Event, bufferSize)
+
+	mu.Lock()
+	if subscribers[videoID] == nil {
+		subscribers[videoID] = map[chan Event]struct{}{}
+	}
+	subscribers[videoID][ch] = struct{}{}
+	mu.Unlock()
+
+	return ch, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(subscribers[videoID], ch)
+		if len(subscribers[videoID]) == 0 {
+			delete(subscribers, videoID)
+		}
+	}
+}
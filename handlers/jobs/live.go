@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+// LiveProgress is the in-process view of a running encode, updated far more
+// often than the database row. It is only known to the instance running the job.
+type LiveProgress struct {
+	Step       string    `json:"step"`
+	Percent    float64   `json:"percent"`
+	Frame      int64     `json:"frame"`
+	Speed      float64   `json:"speed"`
+	ETASeconds float64   `json:"eta_seconds"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+var live sync.Map // video ID -> LiveProgress
+
+// SetLive publishes the live progress of the job's current encode
+func (j *Job) SetLive(p LiveProgress) {
+	p.UpdatedAt = time.Now().UTC()
+	live.Store(j.VideoID, p)
+}
+
+// ClearLive removes the live progress once the job stops running
+func (j *Job) ClearLive() {
+	live.Delete(j.VideoID)
+}
+
+// Live returns the live progress of a video being encoded by this instance
+func Live(videoID string) (LiveProgress, bool) {
+	p, ok := live.Load(videoID)
+	if !ok {
+		return LiveProgress{}, false
+	}
+	return p.(LiveProgress), true
+}
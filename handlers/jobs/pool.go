@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Handler processes one job. It must stop early when ctx is cancelled.
+type Handler func(ctx context.Context, job *Job) error
+
+// Pool runs a fixed number of workers that claim jobs from the database
+type Pool struct {
+	handler     Handler
+	workers     int
+	lease       time.Duration
+	pollEvery   time.Duration
+	maxAttempts int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPool configures a pool from the environment:
+// ENCODE_WORKERS (default 2), JOB_LEASE_SECONDS (default 120) and
+// JOB_MAX_ATTEMPTS (default 3, counting runs lost to crashes).
+func NewPool(handler Handler) *Pool {
+	return &Pool{
+		handler:     handler,
+		workers:     envInt("ENCODE_WORKERS", 2),
+		lease:       time.Duration(envInt("JOB_LEASE_SECONDS", 120)) * time.Second,
+		pollEvery:   5 * time.Second,
+		maxAttempts: envInt("JOB_MAX_ATTEMPTS", 3),
+	}
+}
+
+// Start launches the workers. Jobs left queued or running by a previous
+// process are picked up as soon as they are runnable.
+func (p *Pool) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	hostname, _ := os.Hostname()
+	for i := 0; i < p.workers; i++ {
+		owner := fmt.Sprintf("%s-%d-%s", hostname, i, uuid.New().String()[:8])
+		p.wg.Add(1)
+		go p.run(ctx, owner)
+	}
+
+	fmt.Printf("Started %d encoding workers\n", p.workers)
+}
+
+// Stop cancels running jobs, returns them to the queue and waits for the workers
+func (p *Pool) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+	fmt.Println("Encoding workers stopped")
+}
+
+func (p *Pool) run(ctx context.Context, owner string) {
+	defer p.wg.Done()
+
+	for {
+		job, err := claim(ctx, owner, p.lease)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Failed to claim encoding job: %v\n", err)
+		}
+
+		if job != nil {
+			p.process(ctx, owner, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(p.pollEvery):
+		}
+	}
+}
+
+func (p *Pool) process(ctx context.Context, owner string, job *Job) {
+	// Outcomes are recorded even after shutdown started
+	bg := context.Background()
+
+	if job.Attempts > p.maxAttempts {
+		fmt.Printf("Job %d for video %s exceeded %d attempts\n", job.ID, job.VideoID, p.maxAttempts)
+		if err := finish(bg, job, owner, fmt.Errorf("exceeded maximum attempts (%d)", p.maxAttempts)); err != nil {
+			fmt.Printf("Failed to record job %d outcome: %v\n", job.ID, err)
+		}
+		return
+	}
+
+	fmt.Printf("Worker %s started job %d for video %s (attempt %d)\n", owner, job.ID, job.VideoID, job.Attempts)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Heartbeat: keep the lease alive while the handler runs
+	go func() {
+		ticker := time.NewTicker(p.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				if err := extendLease(jobCtx, job.ID, owner, p.lease); err != nil && jobCtx.Err() == nil {
+					fmt.Printf("Stopping job %d: %v\n", job.ID, err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	jobErr := p.handler(jobCtx, job)
+	job.ClearLive()
+
+	switch {
+	case jobErr != nil && ctx.Err() != nil:
+		// Shutting down: hand the job back instead of failing it
+		if err := release(bg, job, owner); err != nil {
+			fmt.Printf("Failed to release job %d: %v\n", job.ID, err)
+		} else {
+			fmt.Printf("Released job %d for video %s back to the queue\n", job.ID, job.VideoID)
+		}
+	case jobErr != nil && jobCtx.Err() != nil:
+		// The lease was lost; the job runs again once it is claimed by another worker
+		fmt.Printf("Abandoned job %d for video %s: %v\n", job.ID, job.VideoID, jobErr)
+	default:
+		if jobErr != nil {
+			fmt.Printf("Job %d for video %s failed: %v\n", job.ID, job.VideoID, jobErr)
+		} else {
+			fmt.Printf("Job %d for video %s succeeded\n", job.ID, job.VideoID)
+		}
+		if err := finish(bg, job, owner, jobErr); err != nil {
+			fmt.Printf("Failed to record job %d outcome: %v\n", job.ID, err)
+		}
+	}
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
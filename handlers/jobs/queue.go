@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/events"
+)
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// Job is an encoding job stored in the encoding_jobs table
+type Job struct {
+	ID       int64
+	VideoID  string
+	FileName string
+	// Profile names the encoding profile, empty for the default
+	Profile  string
+	Attempts int
+
+	// Last progress written, to throttle updates
+	lastStep    string
+	lastPercent float64
+	lastWrite   time.Time
+}
+
+// Status is the externally visible state of a video's latest job
+type Status struct {
+	JobID     int64     `json:"job_id"`
+	VideoID   string    `json:"video_id"`
+	State     string    `json:"state"`
+	Step      string    `json:"step,omitempty"`
+	Percent   float64   `json:"percent"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Live is set while this instance is running the job
+	Live *LiveProgress `json:"live,omitempty"`
+}
+
+// ErrNotFound is returned when a video has no encoding job
+var ErrNotFound = errors.New("no encoding job found")
+
+// wake lets Enqueue nudge the local pool instead of waiting for the next poll
+var wake = make(chan struct{}, 1)
+
+// Enqueue stores a new job encoding the uploaded file videos/<videoID>/<fileName>
+// with the named encoding profile
+func Enqueue(ctx context.Context, videoID, fileName, profile string) (int64, error) {
+	now := time.Now().UTC()
+	res, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`INSERT INTO encoding_jobs (video_id, file_name, profile, state, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		videoID, fileName, profile, StateQueued, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+
+	events.Publish(events.StateChanged, videoID, map[string]interface{}{"state": StateQueued})
+	return res.LastInsertId()
+}
+
+// claim leases the oldest runnable job to owner. A running job whose lease
+// expired belongs to a worker that crashed and is taken over. It returns
+// nil when there is nothing to do.
+func claim(ctx context.Context, owner string, lease time.Duration) (*Job, error) {
+	db := handlers.CloudSQLDB
+
+	for {
+		now := time.Now().UTC()
+
+		var id int64
+		err := db.QueryRowContext(ctx,
+			`SELECT id FROM encoding_jobs
+			WHERE state = ? OR (state = ? AND lease_expires_at < ?)
+			ORDER BY id LIMIT 1`,
+			StateQueued, StateRunning, now).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The WHERE clause repeats the runnable check so only one worker wins
+		res, err := db.ExecContext(ctx,
+			`UPDATE encoding_jobs
+			SET state = ?, step = NULL, progress = 0, lease_owner = ?, lease_expires_at = ?, attempts = attempts + 1, updated_at = ?
+			WHERE id = ? AND (state = ? OR (state = ? AND lease_expires_at < ?))`,
+			StateRunning, owner, now.Add(lease), now,
+			id, StateQueued, StateRunning, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 0 {
+			// Another worker claimed it first
+			continue
+		}
+
+		job := &Job{ID: id}
+		var profile sql.NullString
+		err = db.QueryRowContext(ctx,
+			`SELECT video_id, file_name, profile, attempts FROM encoding_jobs WHERE id = ?`, id).
+			Scan(&job.VideoID, &job.FileName, &profile, &job.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		job.Profile = profile.String
+
+		events.Publish(events.StateChanged, job.VideoID, map[string]interface{}{"state": StateRunning, "attempt": job.Attempts})
+		return job, nil
+	}
+}
+
+// extendLease keeps a running job owned by owner. It fails if the lease
+// was lost, for example because a heartbeat was missed and another worker took over.
+func extendLease(ctx context.Context, jobID int64, owner string, lease time.Duration) error {
+	now := time.Now().UTC()
+	res, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE encoding_jobs SET lease_expires_at = ?, updated_at = ? WHERE id = ? AND lease_owner = ? AND state = ?`,
+		now.Add(lease), now, jobID, owner, StateRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("lease on job %d lost", jobID)
+	}
+	return nil
+}
+
+// finish records the outcome of a job. A nil error marks it succeeded.
+func finish(ctx context.Context, job *Job, owner string, jobErr error) error {
+	if jobErr != nil {
+		_, err := handlers.CloudSQLDB.ExecContext(ctx,
+			`UPDATE encoding_jobs SET state = ?, error = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+			WHERE id = ? AND lease_owner = ?`,
+			StateFailed, jobErr.Error(), time.Now().UTC(), job.ID, owner)
+		if err == nil {
+			events.Publish(events.VideoFailed, job.VideoID, map[string]interface{}{"step": job.lastStep, "error": jobErr.Error()})
+		}
+		return err
+	}
+
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE encoding_jobs SET state = ?, progress = 100, error = NULL, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ? AND lease_owner = ?`,
+		StateSucceeded, time.Now().UTC(), job.ID, owner)
+	if err == nil {
+		events.Publish(events.VideoReady, job.VideoID, nil)
+	}
+	return err
+}
+
+// release puts an unfinished job back in the queue, used on shutdown
+func release(ctx context.Context, job *Job, owner string) error {
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE encoding_jobs SET state = ?, lease_owner = NULL, lease_expires_at = NULL, updated_at = ?
+		WHERE id = ? AND lease_owner = ?`,
+		StateQueued, time.Now().UTC(), job.ID, owner)
+	if err == nil {
+		events.Publish(events.StateChanged, job.VideoID, map[string]interface{}{"state": StateQueued})
+	}
+	return err
+}
+
+// SetProgress records the step a job is in and its overall percent complete.
+// Writes are throttled to step changes and noticeable progress.
+func (j *Job) SetProgress(ctx context.Context, step string, percent float64) {
+	if step == j.lastStep && percent-j.lastPercent < 1 && time.Since(j.lastWrite) < 5*time.Second {
+		return
+	}
+
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE encoding_jobs SET step = ?, progress = ?, updated_at = ? WHERE id = ?`,
+		step, percent, time.Now().UTC(), j.ID)
+	if err != nil {
+		fmt.Printf("Failed to record progress of job %d: %v\n", j.ID, err)
+		return
+	}
+	if step != j.lastStep {
+		events.Publish(events.StateChanged, j.VideoID, map[string]interface{}{"state": StateRunning, "step": step})
+	}
+	j.lastStep, j.lastPercent, j.lastWrite = step, percent, time.Now()
+}
+
+// LatestStatus returns the status of the most recent job for a video
+func LatestStatus(ctx context.Context, videoID string) (*Status, error) {
+	var status Status
+	var step, message sql.NullString
+	err := handlers.CloudSQLDB.QueryRowContext(ctx,
+		`SELECT id, video_id, state, step, progress, error, attempts, created_at, updated_at
+		FROM encoding_jobs WHERE video_id = ? ORDER BY id DESC LIMIT 1`, videoID).
+		Scan(&status.JobID, &status.VideoID, &status.State, &step, &status.Percent, &message,
+			&status.Attempts, &status.CreatedAt, &status.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status.Step = step.String
+	status.Error = message.String
+	if p, ok := Live(videoID); ok && status.State == StateRunning {
+		status.Live = &p
+	}
+	return &status, nil
+}
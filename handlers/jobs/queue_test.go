@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"packetized-media-streaming/handlers/dbtest"
+)
+
+func TestClaimOrder(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+
+	first, err := Enqueue(ctx, "v1", "a.mp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Enqueue(ctx, "v2", "b.mp4", "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := claim(ctx, "worker-a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.ID != first || job.VideoID != "v1" || job.FileName != "a.mp4" || job.Attempts != 1 {
+		t.Fatalf("first claim: %+v, want job %d of v1", job, first)
+	}
+
+	job, err = claim(ctx, "worker-b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.ID != second || job.Profile != "premium" {
+		t.Fatalf("second claim: %+v, want job %d with profile premium", job, second)
+	}
+
+	// Both jobs hold live leases
+	if job, err := claim(ctx, "worker-c", time.Minute); err != nil || job != nil {
+		t.Fatalf("claim with nothing runnable: %+v, %v", job, err)
+	}
+
+	status, err := LatestStatus(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateRunning || status.Attempts != 1 {
+		t.Errorf("status of claimed job: %+v", status)
+	}
+	if _, err := LatestStatus(ctx, "v3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("status of video without jobs: %v, want ErrNotFound", err)
+	}
+}
+
+func TestClaimExpiredLease(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+
+	if _, err := Enqueue(ctx, "v1", "a.mp4", ""); err != nil {
+		t.Fatal(err)
+	}
+	// A lease already in the past, as left by a worker that stopped heartbeating
+	stale, err := claim(ctx, "worker-a", -time.Second)
+	if err != nil || stale == nil {
+		t.Fatalf("claim: %+v, %v", stale, err)
+	}
+
+	job, err := claim(ctx, "worker-b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.ID != stale.ID || job.Attempts != 2 {
+		t.Fatalf("claim of expired job: %+v, want job %d on attempt 2", job, stale.ID)
+	}
+
+	if err := extendLease(ctx, job.ID, "worker-a", time.Minute); err == nil {
+		t.Error("previous owner extended a lease it lost")
+	}
+	if err := extendLease(ctx, job.ID, "worker-b", time.Minute); err != nil {
+		t.Errorf("owner could not extend its lease: %v", err)
+	}
+
+	// The previous owner's outcome is ignored
+	if err := finish(ctx, stale, "worker-a", errors.New("killed")); err != nil {
+		t.Fatal(err)
+	}
+	status, err := LatestStatus(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateRunning || status.Error != "" {
+		t.Errorf("status after finish by previous owner: %+v", status)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		videoID string
+		err     error
+		state   string
+		message string
+	}{
+		{videoID: "ok", state: StateSucceeded},
+		{videoID: "broken", err: errors.New("ffmpeg exited with status 1"), state: StateFailed, message: "ffmpeg exited with status 1"},
+	}
+	for _, tt := range tests {
+		if _, err := Enqueue(ctx, tt.videoID, "a.mp4", ""); err != nil {
+			t.Fatal(err)
+		}
+		job, err := claim(ctx, "worker", time.Minute)
+		if err != nil || job == nil {
+			t.Fatalf("%s: claim: %+v, %v", tt.videoID, job, err)
+		}
+		job.SetProgress(ctx, "hls_encode", 40)
+
+		if err := finish(ctx, job, "worker", tt.err); err != nil {
+			t.Fatal(err)
+		}
+		status, err := LatestStatus(ctx, tt.videoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != tt.state || status.Error != tt.message {
+			t.Errorf("%s: status %+v, want state %s and error %q", tt.videoID, status, tt.state, tt.message)
+		}
+		if tt.err == nil && status.Percent != 100 {
+			t.Errorf("%s: percent %v after success, want 100", tt.videoID, status.Percent)
+		}
+
+		// A finished job is not claimed again
+		if err := extendLease(ctx, job.ID, "worker", time.Minute); err == nil {
+			t.Errorf("%s: lease extended on a finished job", tt.videoID)
+		}
+	}
+	if job, err := claim(ctx, "worker", time.Minute); err != nil || job != nil {
+		t.Errorf("claim after every job finished: %+v, %v", job, err)
+	}
+}
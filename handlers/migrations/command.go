@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errUsage = errors.New("usage: migrate up | down [steps] | status")
+
+// Command runs the migrate subcommand: up applies pending migrations, down
+// reverts the latest one (or the given number of steps) and status lists them.
+func Command(db *sql.DB, dialect string, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := Up(ctx, db, dialect)
+		for _, m := range done {
+			fmt.Printf("Applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New("steps must be a positive number")
+			}
+			steps = n
+		}
+		done, err := Down(ctx, db, dialect, steps)
+		for _, m := range done {
+			fmt.Printf("Reverted %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("No migrations to revert")
+		}
+		return err
+
+	case "status":
+		states, err := Status(ctx, db, dialect)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+
+	return errUsage
+}
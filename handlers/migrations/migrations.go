@@ -0,0 +1,332 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers"
+)
+
+// Migrations are sql/<dialect>/<version>_<name>.up.sql and the matching
+// .down.sql. Every dialect has the same versions, written in its own DDL.
+// A file may hold several statements, each ending with a semicolon at the end of a line.
+//
+//go:embed sql/mysql/*.sql sql/sqlite/*.sql
+var files embed.FS
+
+// Migration is one schema change and how to revert it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State is a migration and when it was applied, if it was
+type State struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// The named MySQL lock held while migrating, and how long to wait for it
+const (
+	lockName    = "schema_migrations"
+	lockTimeout = 5 * time.Minute
+)
+
+const trackingTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at DATETIME NOT NULL
+)`
+
+// Load returns the embedded migrations of a dialect ordered by version
+func Load(dialect string) ([]Migration, error) {
+	names, err := fs.Glob(files, path.Join("sql", dialect, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", base)
+		}
+		prefix, title, ok := strings.Cut(stem, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", base)
+		}
+
+		body, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		} else if m.Name != title {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status lists every migration with when it was applied
+func Status(ctx context.Context, db *sql.DB, dialect string) ([]State, error) {
+	return status(ctx, db, dialect)
+}
+
+func status(ctx context.Context, q querier, dialect string) ([]State, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]State, len(migrations))
+	for i, m := range migrations {
+		states[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &at
+		}
+	}
+	return states, nil
+}
+
+// Up applies every pending migration in order and returns those it applied
+func Up(ctx context.Context, db *sql.DB, dialect string) ([]Migration, error) {
+	var done []Migration
+	err := locked(ctx, db, dialect, func(conn *sql.Conn) error {
+		// Read once the lock is held, as another instance may just have migrated
+		states, err := status(ctx, conn, dialect)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range states {
+			if s.AppliedAt != nil {
+				continue
+			}
+			applied, err := step(ctx, conn, dialect, s.Version, false, func() error {
+				if err := exec(ctx, conn, s.Up); err != nil {
+					return fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, err)
+				}
+				_, err := conn.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+					s.Version, s.Name, time.Now().UTC())
+				if err != nil {
+					return fmt.Errorf("recording migration %d_%s: %w", s.Version, s.Name, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if applied {
+				done = append(done, s.Migration)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps applied migrations and returns those it reverted
+func Down(ctx context.Context, db *sql.DB, dialect string, steps int) ([]Migration, error) {
+	var done []Migration
+	err := locked(ctx, db, dialect, func(conn *sql.Conn) error {
+		states, err := status(ctx, conn, dialect)
+		if err != nil {
+			return err
+		}
+
+		for i := len(states) - 1; i >= 0 && len(done) < steps; i-- {
+			s := states[i]
+			if s.AppliedAt == nil {
+				continue
+			}
+			if s.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted: no down script", s.Version, s.Name)
+			}
+			reverted, err := step(ctx, conn, dialect, s.Version, true, func() error {
+				if err := exec(ctx, conn, s.Down); err != nil {
+					return fmt.Errorf("reverting migration %d_%s: %w", s.Version, s.Name, err)
+				}
+				if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, s.Version); err != nil {
+					return fmt.Errorf("recording revert of migration %d_%s: %w", s.Version, s.Name, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if reverted {
+				done = append(done, s.Migration)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+// locked runs fn on a connection of its own while no other instance
+// migrates the database, so instances starting together apply each migration
+// once. On MySQL fn holds a named lock throughout; SQLite has one writer at a
+// time, so there every step takes the write lock itself.
+func locked(ctx context.Context, db *sql.DB, dialect string, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if dialect == handlers.DialectMySQL {
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lockName, int(lockTimeout.Seconds())).Scan(&acquired); err != nil {
+			return fmt.Errorf("taking the migration lock: %w", err)
+		}
+		if acquired.Int64 != 1 {
+			return fmt.Errorf("another instance held the migration lock for over %s", lockTimeout)
+		}
+		defer func() {
+			var released sql.NullInt64
+			conn.QueryRowContext(context.Background(), `SELECT RELEASE_LOCK(?)`, lockName).Scan(&released)
+		}()
+	}
+	return fn(conn)
+}
+
+// step applies (or with down, reverts) one migration with fn unless another
+// instance already did, and reports whether it ran. On SQLite it is one
+// write transaction, so the schema change and its record land together;
+// MySQL commits DDL statement by statement and relies on the named lock.
+func step(ctx context.Context, conn *sql.Conn, dialect string, version int64, down bool, fn func() error) (bool, error) {
+	sqlite := dialect == handlers.DialectSQLite
+	if sqlite {
+		// IMMEDIATE waits for other writers before anything is read
+		if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+			return false, err
+		}
+	}
+	rollback := func() {
+		if sqlite {
+			conn.ExecContext(context.Background(), `ROLLBACK`)
+		}
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&n); err != nil {
+		rollback()
+		return false, err
+	}
+	if (n > 0) != down {
+		rollback()
+		return false, nil
+	}
+
+	if err := fn(); err != nil {
+		rollback()
+		return false, err
+	}
+	if sqlite {
+		if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+			rollback()
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// querier is a database or one of its connections
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func appliedVersions(ctx context.Context, q querier) (map[int64]time.Time, error) {
+	if _, err := q.ExecContext(ctx, trackingTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// exec runs each statement of a script in turn, since the MySQL driver
+// only accepts one statement per call
+func exec(ctx context.Context, q querier, script string) error {
+	for _, stmt := range statements(script) {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
@@ -0,0 +1,184 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/dbtest"
+	"packetized-media-streaming/handlers/migrations"
+)
+
+// tables returns the user tables of a SQLite database
+func tables(t *testing.T, ctx context.Context) map[string]bool {
+	t.Helper()
+	rows, err := handlers.CloudSQLDB.QueryContext(ctx,
+		`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestUpDownSQLite(t *testing.T) {
+	db := dbtest.Connect(t)
+	ctx := context.Background()
+	dialect := handlers.DialectSQLite
+
+	all, err := migrations.Load(dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrations.Up(ctx, db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("Up applied %d migrations, want %d", len(applied), len(all))
+	}
+	for _, table := range []string{"encoding_jobs", "webhooks", "webhook_deliveries", "videos", "subtitles"} {
+		if !tables(t, ctx)[table] {
+			t.Errorf("table %s missing after Up", table)
+		}
+	}
+
+	// Applied migrations are not run again
+	if applied, err := migrations.Up(ctx, db, dialect); err != nil || len(applied) != 0 {
+		t.Fatalf("second Up applied %d migrations, err %v", len(applied), err)
+	}
+
+	// Reverting the latest one drops only what it added
+	reverted, err := migrations.Down(ctx, db, dialect, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != all[len(all)-1].Version {
+		t.Fatalf("Down(1) reverted %v, want version %d", reverted, all[len(all)-1].Version)
+	}
+	if names := tables(t, ctx); names["subtitles"] || !names["videos"] {
+		t.Errorf("tables after Down(1): %v", names)
+	}
+
+	reverted, err = migrations.Down(ctx, db, dialect, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(all)-1 {
+		t.Fatalf("Down reverted %d migrations, want %d", len(reverted), len(all)-1)
+	}
+	if names := tables(t, ctx); len(names) != 1 || !names["schema_migrations"] {
+		t.Errorf("tables after reverting everything: %v", names)
+	}
+
+	states, err := migrations.Status(ctx, db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.AppliedAt != nil {
+			t.Errorf("migration %d still applied", s.Version)
+		}
+	}
+
+	// The schema can be built again from scratch
+	if applied, err := migrations.Up(ctx, db, dialect); err != nil || len(applied) != len(all) {
+		t.Fatalf("Up after Down applied %d migrations, err %v", len(applied), err)
+	}
+}
+
+func TestUpRefusesLegacyVideosTable(t *testing.T) {
+	db := dbtest.Connect(t)
+	ctx := context.Background()
+	dialect := handlers.DialectSQLite
+
+	// The table older setups created by hand
+	if _, err := db.ExecContext(ctx, `CREATE TABLE videos (id INTEGER PRIMARY KEY, filename TEXT, path TEXT, duration REAL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrations.Up(ctx, db, dialect)
+	if err == nil {
+		t.Fatal("Up adopted the legacy videos table")
+	}
+	if len(applied) != 2 {
+		t.Errorf("Up applied %d migrations before failing, want 2", len(applied))
+	}
+	states, err := migrations.Status(ctx, db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.Version >= 3 && s.AppliedAt != nil {
+			t.Errorf("migration %d recorded as applied", s.Version)
+		}
+	}
+}
+
+func TestUpConcurrent(t *testing.T) {
+	// Instances sharing one database file, as containers starting together do
+	t.Setenv("DB_MODE", handlers.ModeSQLite)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "streaming.db"))
+	ctx := context.Background()
+	dialect := handlers.DialectSQLite
+
+	dbs := make([]*sql.DB, 8)
+	for i := range dbs {
+		db, err := handlers.ConnectToDB("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		dbs[i] = db
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	applied := make([][]migrations.Migration, len(dbs))
+	errs := make([]error, len(dbs))
+	for i, db := range dbs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			applied[i], errs[i] = migrations.Up(ctx, db, dialect)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	all, err := migrations.Load(dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := map[int64]int{}
+	for i := range dbs {
+		if errs[i] != nil {
+			t.Errorf("instance %d: %v", i, errs[i])
+		}
+		for _, m := range applied[i] {
+			times[m.Version]++
+		}
+	}
+	for _, m := range all {
+		if times[m.Version] != 1 {
+			t.Errorf("migration %d applied %d times", m.Version, times[m.Version])
+		}
+	}
+}
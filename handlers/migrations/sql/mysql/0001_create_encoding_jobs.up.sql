@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS encoding_jobs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	video_id VARCHAR(36) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	state VARCHAR(16) NOT NULL,
+	step VARCHAR(32) NULL,
+	progress DOUBLE NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	lease_owner VARCHAR(128) NULL,
+	lease_expires_at DATETIME NULL,
+	error TEXT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	INDEX idx_encoding_jobs_state (state, lease_expires_at),
+	INDEX idx_encoding_jobs_video (video_id)
+);
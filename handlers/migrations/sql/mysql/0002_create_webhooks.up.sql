@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(128) NOT NULL,
+	events VARCHAR(512) NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	webhook_id BIGINT NOT NULL,
+	event VARCHAR(64) NOT NULL,
+	video_id VARCHAR(36) NOT NULL,
+	payload TEXT NOT NULL,
+	state VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME NULL,
+	last_status_code INT NULL,
+	last_error TEXT NULL,
+	delivered_at DATETIME NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	INDEX idx_webhook_deliveries_due (state, next_attempt_at),
+	INDEX idx_webhook_deliveries_webhook (webhook_id, id)
+);
@@ -0,0 +1,18 @@
+-- No IF NOT EXISTS: a hand-made videos table of an older setup has other
+-- columns, and must be renamed or dropped before this migration runs
+CREATE TABLE videos (
+	id VARCHAR(36) PRIMARY KEY,
+	filename VARCHAR(255) NOT NULL,
+	source_object VARCHAR(512) NOT NULL,
+	size BIGINT NOT NULL,
+	content_type VARCHAR(128) NOT NULL,
+	duration DOUBLE NULL,
+	status VARCHAR(16) NOT NULL,
+	error TEXT NULL,
+	formats TEXT NULL,
+	renditions TEXT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	ready_at DATETIME NULL,
+	INDEX idx_videos_status (status)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS subtitles (
+	video_id VARCHAR(36) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	language VARCHAR(35) NOT NULL,
+	label VARCHAR(255) NOT NULL,
+	source VARCHAR(16) NOT NULL,
+	is_default BOOLEAN NOT NULL DEFAULT FALSE,
+	forced BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (video_id, name)
+);
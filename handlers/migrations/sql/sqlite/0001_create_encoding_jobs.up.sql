@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS encoding_jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	video_id VARCHAR(36) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	state VARCHAR(16) NOT NULL,
+	step VARCHAR(32) NULL,
+	progress DOUBLE NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	lease_owner VARCHAR(128) NULL,
+	lease_expires_at DATETIME NULL,
+	error TEXT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_encoding_jobs_state ON encoding_jobs (state, lease_expires_at);
+CREATE INDEX IF NOT EXISTS idx_encoding_jobs_video ON encoding_jobs (video_id);
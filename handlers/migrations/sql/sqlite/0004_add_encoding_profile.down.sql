@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN profile;
+ALTER TABLE encoding_jobs DROP COLUMN profile;
@@ -0,0 +1,2 @@
+ALTER TABLE encoding_jobs ADD COLUMN profile VARCHAR(64) NULL;
+ALTER TABLE videos ADD COLUMN profile VARCHAR(64) NULL;
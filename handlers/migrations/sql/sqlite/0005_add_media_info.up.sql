@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN media_info TEXT NULL;
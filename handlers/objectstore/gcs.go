@@ -0,0 +1,112 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCS stores objects in a Google Cloud Storage bucket
+type GCS struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+// NewGCS connects to bucket. Without a credentials file the application
+// default credentials (GOOGLE_APPLICATION_CREDENTIALS) are used, falling back
+// to service-account.json when it exists in the working directory.
+func NewGCS(ctx context.Context, bucket, credentialsFile string) (*GCS, error) {
+	if credentialsFile == "" {
+		if _, err := os.Stat("service-account.json"); err == nil && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+			credentialsFile = "service-account.json"
+		}
+	}
+
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	} else if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+		fmt.Println("Warning: GOOGLE_APPLICATION_CREDENTIALS is not set")
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCS{client: client, bucket: client.Bucket(bucket)}, nil
+}
+
+func (s *GCS) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	// Cancelling the context aborts the write instead of committing a partial object
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wc := s.bucket.Object(key).NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := io.Copy(wc, r); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+func (s *GCS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotExist
+	}
+	return rc, err
+}
+
+func (s *GCS) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: attrs.Name, Size: attrs.Size, ContentType: attrs.ContentType, Updated: attrs.Updated}, nil
+}
+
+func (s *GCS) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+
+	var objects []ObjectInfo
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ObjectInfo{Key: attrs.Name, Size: attrs.Size, ContentType: attrs.ContentType, Updated: attrs.Updated})
+	}
+}
+
+func (s *GCS) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *GCS) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return s.bucket.SignedURL(key, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expiry),
+	})
+}
+
+func (s *GCS) Close() error {
+	return s.client.Close()
+}
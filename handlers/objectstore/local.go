@@ -0,0 +1,239 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files below a root directory. Signed URLs point at
+// this service's /objects route (see streaming.ServeObject) and carry an HMAC
+// over the object's key and expiry. For manifests and WebVTT tracks the HMAC
+// covers their directory instead, so players can follow the relative URLs of
+// segments, subtitles and sprites next to them.
+type Local struct {
+	root    string
+	baseURL string
+	key     []byte
+}
+
+// NewLocal stores objects under dir. Without a signing key a random one is
+// generated, so signed URLs do not survive a restart.
+func NewLocal(dir, baseURL, signingKey string) (*Local, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Local{root: root, baseURL: strings.TrimRight(baseURL, "/"), key: key}, nil
+}
+
+// path maps a key to a file below root, rejecting keys that would escape it
+func (s *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+func (s *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.Open(key)
+}
+
+// Open returns the object's file, which supports seeking for range requests
+func (s *Local) Open(key string) (*os.File, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (s *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ContentType: ContentType(key), Updated: info.ModTime()}, nil
+}
+
+func (s *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Only walk the deepest directory that can contain matching keys
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		name, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = name
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ContentType: ContentType(key), Updated: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *Local) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Local) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	sig := s.sign(scopeKey, key, expires)
+	if hasRelativeURLs(key) {
+		sig = s.sign(scopeDir, path.Dir(key), expires)
+	}
+	return fmt.Sprintf("%s/objects/%s/%s/%s", s.baseURL, sig, expires, key), nil
+}
+
+// Verify reports whether sig and expires, taken from a signed URL, grant
+// access to key: key itself or, for a manifest's URL, any directory above
+// key may have been signed.
+func (s *Local) Verify(key, sig, expires string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	if hmac.Equal([]byte(sig), []byte(s.sign(scopeKey, key, expires))) {
+		return true
+	}
+	for dir := path.Dir(key); ; dir = path.Dir(dir) {
+		if hmac.Equal([]byte(sig), []byte(s.sign(scopeDir, dir, expires))) {
+			return true
+		}
+		if !strings.Contains(dir, "/") {
+			return false
+		}
+	}
+}
+
+// What a signature covers, part of the signed message so a key's signature
+// never passes for a directory's
+const (
+	scopeKey = "key"
+	scopeDir = "dir"
+)
+
+// hasRelativeURLs reports whether players resolve URLs found in an object
+// against its own: HLS playlists, DASH manifests and WebVTT tracks
+func hasRelativeURLs(key string) bool {
+	switch path.Ext(key) {
+	case ".m3u8", ".mpd", ".vtt":
+		return true
+	}
+	return false
+}
+
+func (s *Local) sign(scope, name, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(scope + "\n" + name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Local) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package objectstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedParts splits a URL from Local.SignedURL into its signature, expiry
+// and key
+func signedParts(t *testing.T, url string) (sig, expires, key string) {
+	t.Helper()
+	rest, ok := strings.CutPrefix(url, "http://localhost/objects/")
+	if !ok {
+		t.Fatalf("unexpected signed URL %q", url)
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	return parts[0], parts[1], parts[2]
+}
+
+func TestLocalSignedURLScope(t *testing.T) {
+	s, err := NewLocal(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		signed  string
+		allowed []string
+		denied  []string
+	}{
+		{
+			signed:  "videos/v1/CMAF/master.m3u8",
+			allowed: []string{"videos/v1/CMAF/media_0.m3u8", "videos/v1/CMAF/subtitles/en.vtt"},
+			denied:  []string{"videos/v1/source.mp4", "videos/v1/HLS/360p.m3u8"},
+		},
+		{
+			signed:  "videos/v1/DASH/manifest.mpd",
+			allowed: []string{"videos/v1/DASH/chunk-stream0-1.m4s"},
+			denied:  []string{"videos/v1/source.mp4"},
+		},
+		{
+			signed: "videos/v1/source.mp4",
+			denied: []string{"videos/v1/thumbnails/poster.jpg", "videos/v1/CMAF/master.m3u8"},
+		},
+		{
+			signed: "videos/v1/thumbnails/poster.jpg",
+			denied: []string{"videos/v1/thumbnails/160/001.jpg", "videos/v1/source.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		url, err := s.SignedURL(ctx, tt.signed, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, expires, key := signedParts(t, url)
+		if key != tt.signed {
+			t.Fatalf("URL of %s names key %s", tt.signed, key)
+		}
+		if !s.Verify(key, sig, expires) {
+			t.Errorf("URL of %s does not grant itself", tt.signed)
+		}
+		for _, other := range tt.allowed {
+			if !s.Verify(other, sig, expires) {
+				t.Errorf("URL of %s does not grant %s", tt.signed, other)
+			}
+		}
+		for _, other := range tt.denied {
+			if s.Verify(other, sig, expires) {
+				t.Errorf("URL of %s grants %s", tt.signed, other)
+			}
+		}
+	}
+}
+
+func TestLocalVerifyExpired(t *testing.T) {
+	s, err := NewLocal(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := s.SignedURL(context.Background(), "videos/v1/source.mp4", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, expires, key := signedParts(t, url)
+	if s.Verify(key, sig, expires) {
+		t.Error("expired URL is accepted")
+	}
+}
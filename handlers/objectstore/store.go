@@ -0,0 +1,128 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrNotExist is returned by Get and Stat when the key has no object
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	Updated     time.Time
+}
+
+// Store is the object storage used for uploads, encoded outputs and signed URLs.
+// Keys are slash separated paths such as videos/<id>/DASH/manifest.mpd.
+type Store interface {
+	// Put writes the object. If reading r fails nothing is committed.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns every object whose key starts with prefix, ordered by key
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes the object; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	Close() error
+}
+
+// Default is the store selected by Init
+var Default Store
+
+// Init selects the backend from STORAGE_BACKEND ("gcs" by default, "s3" or "local")
+func Init() error {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = "gcs"
+	}
+
+	var err error
+	switch backend {
+	case "gcs":
+		bucket := os.Getenv("GCS_BUCKET")
+		if bucket == "" {
+			bucket = "packetized-media-bucket"
+		}
+		Default, err = NewGCS(context.Background(), bucket, os.Getenv("GCS_CREDENTIALS_FILE"))
+	case "s3":
+		partSizeMB, _ := strconv.ParseUint(os.Getenv("S3_PART_SIZE_MB"), 10, 64)
+		if partSizeMB == 0 {
+			partSizeMB = 16
+		}
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "s3." + region + ".amazonaws.com"
+		}
+		Default, err = NewS3(S3Config{
+			Endpoint:        endpoint,
+			Region:          region,
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			UseSSL:          os.Getenv("S3_USE_SSL") != "false",
+			PathStyle:       os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+			PartSize:        partSizeMB * 1024 * 1024,
+		})
+	case "local":
+		dir := os.Getenv("LOCAL_STORAGE_DIR")
+		if dir == "" {
+			dir = "./storage"
+		}
+		baseURL := os.Getenv("LOCAL_STORAGE_BASE_URL")
+		if baseURL == "" {
+			port := os.Getenv("PORT")
+			if port == "" {
+				port = "8080"
+			}
+			baseURL = "http://localhost:" + port
+		}
+		Default, err = NewLocal(dir, baseURL, os.Getenv("LOCAL_STORAGE_SIGNING_KEY"))
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s storage: %w", backend, err)
+	}
+
+	fmt.Printf("Using %s object storage\n", backend)
+	return nil
+}
+
+// ContentType returns the MIME type served for an object name
+func ContentType(filename string) string {
+	switch filepath.Ext(filename) {
+	case ".mp4":
+		return "video/mp4"
+	case ".m3u8":
+		return "application/x-mpegURL" // Correct MIME type for HLS
+	case ".mpd":
+		return "application/dash+xml" // Correct MIME type for DASH
+	case ".ts":
+		return "video/mp2t" // HLS Transport Stream segments
+	case ".m4s":
+		return "video/iso.segment" // DASH Media Segments
+	case ".json":
+		return "application/json"
+	case ".jpg":
+		return "image/jpeg"
+	case ".vtt":
+		return "text/vtt"
+	default:
+		return "application/octet-stream" // Default if unknown
+	}
+}
@@ -0,0 +1,25 @@
+// Package objectstoretest gives tests a local object store in place of the
+// configured one
+package objectstoretest
+
+import (
+	"testing"
+
+	"packetized-media-streaming/handlers/objectstore"
+)
+
+// Local makes objectstore.Default a local store in a temporary directory,
+// signing URLs under http://localhost, and restores the previous store when
+// the test ends
+func Local(t testing.TB) *objectstore.Local {
+	t.Helper()
+	store, err := objectstore.NewLocal(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := objectstore.Default
+	objectstore.Default = store
+	t.Cleanup(func() { objectstore.Default = previous })
+	return store
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// connectSQLite opens the SQLite database file at SQLITE_PATH (default
+// ./data/streaming.db), creating it if needed. ":memory:" keeps it in memory.
+func connectSQLite() (*sql.DB, error) {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = filepath.Join("data", "streaming.db")
+	}
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	// Waiting on locks instead of failing with SQLITE_BUSY lets the HTTP
+	// handlers and workers share the file; times are stored in a sortable format
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return db, nil
+}
@@ -4,33 +4,12 @@ import (
 	"context"
 	"time"
 
-	"cloud.google.com/go/storage"
-)
-
-const (
-	bucketName = "packetized-media-bucket"
+	"packetized-media-streaming/handlers/objectstore"
 )
 
 func GenerateSignedURL(objectPath string) (string, error) {
 	ctx := context.Background()
-	
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return "", err
-	}
-	defer client.Close()
-
-	// Expiry time for signed URL
-	expiration := time.Now().Add(1 * time.Hour)
-
-	// Generate signed URL
-	url, err := client.Bucket(bucketName).SignedURL(objectPath, &storage.SignedURLOptions{
-		Method: "GET",
-		Expires: expiration,
-	})
-	if err != nil {
-		return "", err
-	}
 
-	return url, nil
-}
\ No newline at end of file
+	// Signed URLs expire after an hour
+	return objectstore.Default.SignedURL(ctx, objectPath, 1*time.Hour)
+}
@@ -1,9 +1,12 @@
 package streaming
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
+	"packetized-media-streaming/handlers/videos"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,9 +20,15 @@ func GetVideoURL(c *gin.Context) {
 	}
 
 	// Determine manifest file path
-	objectPath := fmt.Sprintf("videos/%s/%s/manifest.mpd", videoID, format)
-	if format == "HLS" {
-		objectPath = fmt.Sprintf("videos/%s/%s/playlist.m3u8", videoID, format)
+	objectPath, status, err := manifestPath(c.Request.Context(), videoID, format)
+	if err != nil {
+		fmt.Printf("Failed to load video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video"})
+		return
+	}
+	if objectPath == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is not ready", "status": status})
+		return
 	}
 
 	// Generate signed URL
@@ -29,6 +38,40 @@ func GetVideoURL(c *gin.Context) {
 		return
 	}
 
+	response := gin.H{"signed_url": url}
+
+	// Seek-preview track and sprite sheets, if the video has them
+	vtt, sprites, err := spriteURLs(c.Request.Context(), videoID)
+	if err != nil {
+		fmt.Printf("Failed to sign sprites of video %s: %v\n", videoID, err)
+	} else if vtt != "" {
+		response["thumbnails_vtt"] = vtt
+		response["sprites"] = sprites
+	}
+
 	// Return the signed URL
-	c.JSON(http.StatusOK, gin.H{"signed_url": url})
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, response)
+}
+
+// manifestPath returns the manifest object of a video's format as recorded
+// when it was encoded, or "" with the video's status while it is not ready.
+// Videos uploaded before records were kept use the fixed HLS and DASH paths.
+func manifestPath(ctx context.Context, videoID, format string) (string, string, error) {
+	video, err := videos.Get(ctx, videoID)
+	if err == videos.ErrNotFound {
+		if format == "HLS" {
+			return fmt.Sprintf("videos/%s/HLS/playlist.m3u8", videoID), "", nil
+		}
+		return fmt.Sprintf("videos/%s/DASH/manifest.mpd", videoID), "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, f := range video.Formats {
+		if f.Name == format {
+			return f.Manifest, video.Status, nil
+		}
+	}
+	return "", video.Status, nil
+}
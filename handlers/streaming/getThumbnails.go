@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetThumbnails returns signed URLs of a video's poster frame and of its
+// thumbnails, grouped by width and in playback order
+func GetThumbnails(c *gin.Context) {
+	videoID := c.Param("videoID")
+	ctx := c.Request.Context()
+
+	prefix := fmt.Sprintf("videos/%s/thumbnails/", videoID)
+	objects, err := objectstore.Default.List(ctx, prefix)
+	if err != nil {
+		fmt.Printf("Failed to list thumbnails of video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list thumbnails"})
+		return
+	}
+
+	if len(objects) == 0 {
+		video, err := videos.Get(ctx, videoID)
+		if err == videos.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+			return
+		}
+		if err != nil {
+			fmt.Printf("Failed to load video %s: %v\n", videoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video"})
+			return
+		}
+		if video.Status != videos.StatusReady {
+			c.JSON(http.StatusConflict, gin.H{"error": "Video is not ready", "status": video.Status})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video has no thumbnails"})
+		return
+	}
+
+	// Objects are listed by key, so thumbnails 001.jpg onwards come in order
+	var poster string
+	thumbnails := map[string][]string{}
+	for _, obj := range objects {
+		// Thumbnails are in a folder per width
+		name := strings.TrimPrefix(obj.Key, prefix)
+		width, _, nested := strings.Cut(name, "/")
+		_, err := strconv.Atoi(width)
+		isThumbnail := nested && err == nil
+		if name != "poster.jpg" && !isThumbnail {
+			continue
+		}
+
+		url, err := GenerateSignedURL(obj.Key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate signed URL"})
+			return
+		}
+		if isThumbnail {
+			thumbnails[width] = append(thumbnails[width], url)
+		} else {
+			poster = url
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"poster_url": poster, "thumbnails": thumbnails})
+}
+
+// spriteURLs signs a video's WebVTT thumbnail track and its sprite sheets,
+// returning "" for a video without them
+func spriteURLs(ctx context.Context, videoID string) (string, []string, error) {
+	objects, err := objectstore.Default.List(ctx, fmt.Sprintf("videos/%s/sprites/", videoID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var vtt string
+	sprites := []string{}
+	for _, obj := range objects {
+		url, err := GenerateSignedURL(obj.Key)
+		if err != nil {
+			return "", nil, err
+		}
+		if path.Base(obj.Key) == "thumbnails.vtt" {
+			vtt = url
+		} else if path.Ext(obj.Key) == ".jpg" {
+			sprites = append(sprites, url)
+		}
+	}
+	return vtt, sprites, nil
+}
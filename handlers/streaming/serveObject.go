@@ -0,0 +1,46 @@
+package streaming
+
+import (
+	"net/http"
+	"strings"
+
+	"packetized-media-streaming/handlers/objectstore"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeObject serves signed URLs issued by the local storage backend
+func ServeObject(c *gin.Context) {
+	local, ok := objectstore.Default.(*objectstore.Local)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object serving is only available with local storage"})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !local.Verify(key, c.Param("sig"), c.Param("expires")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+		return
+	}
+
+	f, err := local.Open(key)
+	if err == objectstore.ErrNotExist {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid object key"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+
+	// ServeContent handles Range requests, which players use for seeking
+	c.Header("Content-Type", objectstore.ContentType(key))
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,137 @@
+package subtitles
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFileSize bounds an uploaded subtitle file
+const maxFileSize = 5 * 1024 * 1024
+
+// languageTag accepts BCP 47 tags such as en, pt-BR or zh-Hant
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// UploadSubtitle adds an SRT or WebVTT file to a video as the track of a
+// language, replacing an earlier upload of it. The form takes the file, its
+// language, and optionally a label shown in players and the default and
+// forced flags.
+func UploadSubtitle(c *gin.Context) {
+	videoID := c.Param("videoID")
+	ctx := c.Request.Context()
+
+	if _, err := videos.Get(ctx, videoID); err == videos.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	} else if err != nil {
+		fmt.Printf("Failed to load video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video"})
+		return
+	}
+
+	language := c.PostForm("language")
+	if !languageTag.MatchString(language) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "language must be a language tag such as en or pt-BR"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	if fileHeader.Size > maxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Subtitle file exceeds 5MB limit"})
+		return
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	vtt, err := ToWebVTT(data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Uploaded file is not an SRT or WebVTT file with cues"})
+		return
+	}
+
+	s := Subtitle{
+		VideoID:  videoID,
+		Name:     strings.ToLower(language),
+		Language: language,
+		Label:    strings.TrimSpace(c.PostForm("label")),
+		Source:   SourceUpload,
+		Default:  c.PostForm("default") == "true",
+		Forced:   c.PostForm("forced") == "true",
+	}
+	if s.Label == "" {
+		s.Label = language
+	}
+	if s.Forced {
+		// A forced track sits beside the full one of its language
+		s.Name += "-forced"
+	}
+
+	if err := Add(ctx, &s, vtt); err != nil {
+		fmt.Printf("Failed to store subtitles %s of video %s: %v\n", s.Name, videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store subtitles"})
+		return
+	}
+	if err := Publish(ctx, videoID); err != nil {
+		fmt.Printf("Failed to publish subtitles of video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add subtitles to the manifests"})
+		return
+	}
+
+	if s.URL, err = objectstore.Default.SignedURL(ctx, s.Object(), time.Hour); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate signed URL"})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// ListSubtitles returns a video's subtitle tracks with signed URLs of their
+// WebVTT files
+func ListSubtitles(c *gin.Context) {
+	videoID := c.Param("videoID")
+	ctx := c.Request.Context()
+
+	if _, err := videos.Get(ctx, videoID); err == videos.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	} else if err != nil {
+		fmt.Printf("Failed to load video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video"})
+		return
+	}
+
+	list, err := List(ctx, videoID)
+	if err != nil {
+		fmt.Printf("Failed to list subtitles of video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list subtitles"})
+		return
+	}
+	for i := range list {
+		if list[i].URL, err = objectstore.Default.SignedURL(ctx, list[i].Object(), time.Hour); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate signed URL"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subtitles": list})
+}
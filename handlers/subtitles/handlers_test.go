@@ -0,0 +1,190 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"packetized-media-streaming/handlers/dbtest"
+	"packetized-media-streaming/handlers/objectstoretest"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+)
+
+const hlsMaster = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\n360p.m3u8\n"
+
+func subtitlesRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dbtest.Open(t)
+	objectstoretest.Local(t)
+
+	r := gin.New()
+	r.POST("/videos/:videoID/subtitles", UploadSubtitle)
+	r.GET("/videos/:videoID/subtitles", ListSubtitles)
+	return r
+}
+
+// encodedVideo stores a video whose HLS encode has finished
+func encodedVideo(t *testing.T, id string) {
+	t.Helper()
+	ctx := context.Background()
+	v := &videos.Video{ID: id, FileName: "a.mp4", SourceObject: "videos/" + id + "/a.mp4"}
+	if err := videos.Create(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "videos/" + id + "/HLS/master.m3u8"
+	if err := putObject(ctx, manifest, []byte(hlsMaster)); err != nil {
+		t.Fatal(err)
+	}
+	if err := videos.SetOutputs(ctx, id, []videos.Format{{Name: "HLS", Manifest: manifest}}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func uploadRequest(t *testing.T, r *gin.Engine, videoID string, fields map[string]string, file string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	part, err := form.CreateFormFile("file", "track.srt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, file)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/videos/"+videoID+"/subtitles", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadSubtitle(t *testing.T) {
+	r := subtitlesRouter(t)
+	encodedVideo(t, "v1")
+	srt := "1\n00:00:01,000 --> 00:00:02,500\nHello\n"
+
+	tests := []struct {
+		name    string
+		videoID string
+		fields  map[string]string
+		file    string
+		status  int
+	}{
+		{"unknown video", "missing", map[string]string{"language": "en"}, srt, http.StatusNotFound},
+		{"bad language", "v1", map[string]string{"language": "english!"}, srt, http.StatusBadRequest},
+		{"not subtitles", "v1", map[string]string{"language": "en"}, "just some text", http.StatusUnprocessableEntity},
+		{"srt", "v1", map[string]string{"language": "pt-BR", "label": "Português", "default": "true"}, srt, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := uploadRequest(t, r, tt.videoID, tt.fields, tt.file)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+
+	ctx := context.Background()
+	vtt, err := readObject(ctx, "videos/v1/subtitles/pt-br.vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(vtt), "WEBVTT") || !strings.Contains(string(vtt), "00:00:01.000 --> 00:00:02.500") {
+		t.Errorf("stored track is not the converted WebVTT:\n%s", vtt)
+	}
+
+	master, err := readObject(ctx, "videos/v1/HLS/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Português",LANGUAGE="pt-BR",DEFAULT=YES,AUTOSELECT=YES,FORCED=NO,URI="subtitles/pt-br.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,SUBTITLES="subs"`,
+	} {
+		if !strings.Contains(string(master), want) {
+			t.Errorf("master playlist lacks %s:\n%s", want, master)
+		}
+	}
+	if _, err := readObject(ctx, "videos/v1/HLS/subtitles/pt-br.vtt"); err != nil {
+		t.Errorf("track not copied next to the manifest: %v", err)
+	}
+}
+
+func TestListSubtitles(t *testing.T) {
+	r := subtitlesRouter(t)
+	encodedVideo(t, "v1")
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+	for _, language := range []string{"fr", "en"} {
+		if w := uploadRequest(t, r, "v1", map[string]string{"language": language}, srt); w.Code != http.StatusCreated {
+			t.Fatalf("upload %s: status %d", language, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/videos/v1/subtitles", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var resp struct {
+		Subtitles []Subtitle `json:"subtitles"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Subtitles) != 2 || resp.Subtitles[0].Name != "en" || resp.Subtitles[1].Name != "fr" {
+		t.Fatalf("subtitles %+v, want en and fr", resp.Subtitles)
+	}
+	for _, s := range resp.Subtitles {
+		if s.Source != SourceUpload || !strings.HasPrefix(s.URL, "http://localhost/objects/") {
+			t.Errorf("track %s: source %q, URL %q", s.Name, s.Source, s.URL)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/videos/missing/subtitles", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown video: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUploadSubtitleReplaces(t *testing.T) {
+	r := subtitlesRouter(t)
+	encodedVideo(t, "v1")
+
+	var tracks []Subtitle
+	for _, text := range []string{"Hello", "Hi"} {
+		w := uploadRequest(t, r, "v1", map[string]string{"language": "en"}, "1\n00:00:01,000 --> 00:00:02,000\n"+text+"\n")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("upload: status %d", w.Code)
+		}
+		var s Subtitle
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, s)
+	}
+
+	if !tracks[1].CreatedAt.Equal(tracks[0].CreatedAt) {
+		t.Errorf("replaced track reports created_at %v, first upload %v", tracks[1].CreatedAt, tracks[0].CreatedAt)
+	}
+	if tracks[1].UpdatedAt.Before(tracks[0].UpdatedAt) {
+		t.Errorf("replaced track updated_at %v is before %v", tracks[1].UpdatedAt, tracks[0].UpdatedAt)
+	}
+	list, err := List(context.Background(), "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !list[0].CreatedAt.Equal(tracks[0].CreatedAt) {
+		t.Errorf("stored tracks %+v", list)
+	}
+}
@@ -0,0 +1,211 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+)
+
+// hlsGroup is the GROUP-ID of the subtitle renditions in HLS master playlists
+const hlsGroup = "subs"
+
+var (
+	adaptationSetID = regexp.MustCompile(`<AdaptationSet id="(\d+)"`)
+	// Text adaptation sets written by a previous publish
+	textAdaptationSet = regexp.MustCompile(`(?s)\n?[ \t]*<AdaptationSet [^>]*contentType="text"[^>]*>.*?</AdaptationSet>`)
+)
+
+// Publish copies a video's subtitle tracks into a subtitles folder next to
+// each of its manifests and rewrites the manifests to list them. A video
+// that is not encoded yet has no manifests; its tracks are published when
+// the encode finishes.
+func Publish(ctx context.Context, videoID string) error {
+	video, err := videos.Get(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	list, err := List(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	if len(video.Formats) == 0 || len(list) == 0 {
+		return nil
+	}
+
+	// Tracks are read once and copied next to every manifest
+	tracks := make(map[string][]byte, len(list))
+	for _, s := range list {
+		data, err := readObject(ctx, s.Object())
+		if err != nil {
+			return fmt.Errorf("reading subtitles %s: %w", s.Name, err)
+		}
+		tracks[s.Name] = data
+	}
+
+	for _, f := range video.Formats {
+		dir := path.Dir(f.Manifest)
+		for _, s := range list {
+			key := fmt.Sprintf("%s/subtitles/%s.vtt", dir, s.Name)
+			if err := putObject(ctx, key, tracks[s.Name]); err != nil {
+				return err
+			}
+		}
+
+		manifest, err := readObject(ctx, f.Manifest)
+		if err != nil {
+			return fmt.Errorf("reading %s manifest: %w", f.Name, err)
+		}
+		switch f.Name {
+		case "HLS":
+			// HLS renditions are media playlists, here of one segment: the whole file
+			for _, s := range list {
+				key := fmt.Sprintf("%s/subtitles/%s.m3u8", dir, s.Name)
+				if err := putObject(ctx, key, mediaPlaylist(s.Name, trackDuration(video, tracks[s.Name]))); err != nil {
+					return err
+				}
+			}
+			manifest = []byte(withHLSSubtitles(string(manifest), list))
+		case "DASH":
+			manifest = []byte(withDASHSubtitles(string(manifest), list))
+		default:
+			continue
+		}
+		if err := putObject(ctx, f.Manifest, manifest); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Published %d subtitle tracks of video %s\n", len(list), videoID)
+	return nil
+}
+
+// trackDuration is the duration the subtitle playlist declares: the video's,
+// or the end of the last cue while the duration is unknown
+func trackDuration(video *videos.Video, vtt []byte) float64 {
+	if video.Duration > 0 {
+		return video.Duration
+	}
+	end, _ := lastCueEnd(string(vtt))
+	return float64(end) / 1000
+}
+
+// mediaPlaylist is the HLS playlist of a WebVTT track served as one segment
+func mediaPlaylist(name string, duration float64) []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Max(1, math.Ceil(duration))))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s.vtt\n#EXT-X-ENDLIST\n", duration, name)
+	return []byte(b.String())
+}
+
+// withHLSSubtitles replaces the subtitle renditions of a master playlist
+// with list and points every variant at them
+func withHLSSubtitles(master string, list []Subtitle) string {
+	var media []string
+	hasDefault := false
+	for _, s := range list {
+		attrs := fmt.Sprintf(`TYPE=SUBTITLES,GROUP-ID="%s",NAME="%s"`, hlsGroup, quoted(s.Label))
+		if s.Language != "" {
+			attrs += fmt.Sprintf(`,LANGUAGE="%s"`, quoted(s.Language))
+		}
+		// Only one rendition of a group may be the default
+		isDefault := s.Default && !hasDefault
+		hasDefault = hasDefault || isDefault
+		attrs += ",DEFAULT=" + yesNo(isDefault) + ",AUTOSELECT=YES,FORCED=" + yesNo(s.Forced)
+		media = append(media, fmt.Sprintf(`#EXT-X-MEDIA:%s,URI="subtitles/%s.m3u8"`, attrs, s.Name))
+	}
+
+	groupAttr := fmt.Sprintf(`,SUBTITLES="%s"`, hlsGroup)
+	var out []string
+	for _, line := range strings.Split(master, "\n") {
+		if strings.HasPrefix(line, "#EXT-X-MEDIA:TYPE=SUBTITLES,") {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if media != nil {
+				// Renditions go before the first variant
+				out = append(out, media...)
+				media = nil
+			}
+			line = strings.Replace(line, groupAttr, "", 1) + groupAttr
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// withDASHSubtitles replaces the text adaptation sets of a manifest with an
+// adaptation set per track of list
+func withDASHSubtitles(manifest string, list []Subtitle) string {
+	manifest = textAdaptationSet.ReplaceAllString(manifest, "")
+
+	// Ids must be unique within the period
+	nextID := 0
+	for _, m := range adaptationSetID.FindAllStringSubmatch(manifest, -1) {
+		id, _ := strconv.Atoi(m[1])
+		nextID = max(nextID, id+1)
+	}
+
+	var sets strings.Builder
+	for i, s := range list {
+		role := "subtitle"
+		if s.Forced {
+			role = "forced-subtitle"
+		}
+		lang := ""
+		if s.Language != "" {
+			lang = fmt.Sprintf(` lang="%s"`, html.EscapeString(s.Language))
+		}
+		fmt.Fprintf(&sets, "\t\t<AdaptationSet id=\"%d\" contentType=\"text\" mimeType=\"text/vtt\"%s>\n", nextID+i, lang)
+		fmt.Fprintf(&sets, "\t\t\t<Role schemeIdUri=\"urn:mpeg:dash:role:2011\" value=\"%s\"/>\n", role)
+		fmt.Fprintf(&sets, "\t\t\t<Label>%s</Label>\n", html.EscapeString(s.Label))
+		fmt.Fprintf(&sets, "\t\t\t<Representation id=\"subtitles-%s\" bandwidth=\"256\">\n", html.EscapeString(s.Name))
+		fmt.Fprintf(&sets, "\t\t\t\t<BaseURL>subtitles/%s.vtt</BaseURL>\n", html.EscapeString(s.Name))
+		sets.WriteString("\t\t\t</Representation>\n\t\t</AdaptationSet>\n")
+	}
+
+	periodEnd := strings.LastIndex(manifest, "</Period>")
+	if periodEnd < 0 {
+		return manifest
+	}
+	// </Period> is indented by a tab
+	lineStart := strings.LastIndex(manifest[:periodEnd], "\n") + 1
+	return manifest[:lineStart] + sets.String() + manifest[lineStart:]
+}
+
+// quoted makes a value safe for an HLS quoted string, which cannot hold
+// double quotes or line breaks
+func quoted(value string) string {
+	return strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(value)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func readObject(ctx context.Context, key string) ([]byte, error) {
+	rc, err := objectstore.Default.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func putObject(ctx context.Context, key string, data []byte) error {
+	return objectstore.Default.Put(ctx, key, bytes.NewReader(data), objectstore.ContentType(key))
+}
@@ -0,0 +1,98 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/objectstore"
+)
+
+// Where a subtitle track came from
+const (
+	SourceUpload   = "upload"
+	SourceEmbedded = "embedded"
+)
+
+// Subtitle is a WebVTT track of a video, a row of the subtitles table. Name
+// is unique per video and names its files, <name>.vtt and <name>.m3u8.
+type Subtitle struct {
+	VideoID   string    `json:"video_id"`
+	Name      string    `json:"name"`
+	Language  string    `json:"language"`
+	Label     string    `json:"label"`
+	Source    string    `json:"source"`
+	Default   bool      `json:"default"`
+	Forced    bool      `json:"forced"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Object is the key of the track's WebVTT file, the copy manifests are
+// published from
+func (s *Subtitle) Object() string {
+	return fmt.Sprintf("videos/%s/subtitles/%s.vtt", s.VideoID, s.Name)
+}
+
+// Add stores the WebVTT file of a track and records it. Manifests list it
+// once the video is published.
+func Add(ctx context.Context, s *Subtitle, vtt []byte) error {
+	if err := objectstore.Default.Put(ctx, s.Object(), bytes.NewReader(vtt), objectstore.ContentType(s.Object())); err != nil {
+		return err
+	}
+	return Save(ctx, s)
+}
+
+// Save stores a track, replacing the one of the same name, whose creation
+// time it keeps
+func Save(ctx context.Context, s *Subtitle) error {
+	now := time.Now().UTC()
+	s.UpdatedAt = now
+
+	err := handlers.CloudSQLDB.QueryRowContext(ctx,
+		`SELECT created_at FROM subtitles WHERE video_id = ? AND name = ?`, s.VideoID, s.Name).Scan(&s.CreatedAt)
+	if err == nil {
+		_, err := handlers.CloudSQLDB.ExecContext(ctx,
+			`UPDATE subtitles SET language = ?, label = ?, source = ?, is_default = ?, forced = ?, updated_at = ?
+			WHERE video_id = ? AND name = ?`,
+			s.Language, s.Label, s.Source, s.Default, s.Forced, now, s.VideoID, s.Name)
+		return err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	s.CreatedAt = now
+	_, err = handlers.CloudSQLDB.ExecContext(ctx,
+		`INSERT INTO subtitles (video_id, name, language, label, source, is_default, forced, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.VideoID, s.Name, s.Language, s.Label, s.Source, s.Default, s.Forced, now, now)
+	return err
+}
+
+// List returns the tracks of a video ordered by name
+func List(ctx context.Context, videoID string) ([]Subtitle, error) {
+	rows, err := handlers.CloudSQLDB.QueryContext(ctx,
+		`SELECT video_id, name, language, label, source, is_default, forced, created_at, updated_at
+		FROM subtitles WHERE video_id = ? ORDER BY name`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Subtitle{}
+	for rows.Next() {
+		var s Subtitle
+		if err := rows.Scan(&s.VideoID, &s.Name, &s.Language, &s.Label, &s.Source, &s.Default, &s.Forced,
+			&s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,126 @@
+package subtitles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is returned for a file that is neither SRT nor WebVTT, or has
+// no cues
+var ErrInvalid = errors.New("not an SRT or WebVTT file with cues")
+
+var (
+	// A cue timing line, "00:01:02,500 --> 00:01:04,000" in SRT and
+	// "01:02.500 --> 01:04.000 align:start" in WebVTT, where hours are optional
+	// and some SRT writers leave out the milliseconds
+	cueTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{1,2}(?:[,.]\d{1,3})?)\s*-->\s*((?:\d+:)?\d{1,2}:\d{1,2}(?:[,.]\d{1,3})?)(.*)$`)
+	// Markup some SRT files carry that WebVTT does not have: font tags and
+	// ASS override blocks such as {\an8}
+	srtMarkup = regexp.MustCompile(`</?font[^>]*>|\{\\[^}]*\}`)
+)
+
+// ToWebVTT returns a subtitle file as WebVTT, converting it from SRT unless
+// it already is WebVTT
+func ToWebVTT(data []byte) ([]byte, error) {
+	text := string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	if isWebVTT(text) {
+		if _, ok := lastCueEnd(text); !ok {
+			return nil, ErrInvalid
+		}
+		return []byte(text), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	cues := 0
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			m := cueTiming.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			start, serr := parseTimestamp(m[1])
+			end, eerr := parseTimestamp(m[2])
+			if serr != nil || eerr != nil {
+				break
+			}
+
+			// The cue number before the timing is kept as the cue identifier
+			b.WriteString("\n")
+			if i > 0 {
+				b.WriteString(strings.TrimSpace(lines[i-1]) + "\n")
+			}
+			fmt.Fprintf(&b, "%s --> %s\n", timestamp(start), timestamp(end))
+			for _, textLine := range lines[i+1:] {
+				// "-->" would start a new cue in WebVTT
+				textLine = strings.ReplaceAll(srtMarkup.ReplaceAllString(textLine, ""), "-->", "->")
+				b.WriteString(textLine + "\n")
+			}
+			cues++
+			break
+		}
+	}
+	if cues == 0 {
+		return nil, ErrInvalid
+	}
+	return []byte(b.String()), nil
+}
+
+// isWebVTT reports whether text starts with the WebVTT signature
+func isWebVTT(text string) bool {
+	rest, ok := strings.CutPrefix(text, "WEBVTT")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n')
+}
+
+// lastCueEnd is the latest end time in milliseconds of a file's cues
+func lastCueEnd(text string) (int64, bool) {
+	var last int64
+	found := false
+	for _, line := range strings.Split(text, "\n") {
+		m := cueTiming.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if end, err := parseTimestamp(m[2]); err == nil {
+			last, found = max(last, end), true
+		}
+	}
+	return last, found
+}
+
+// parseTimestamp parses [HH:]MM:SS,mmm or [HH:]MM:SS.mmm into milliseconds
+func parseTimestamp(value string) (int64, error) {
+	clock, frac, _ := strings.Cut(strings.Replace(value, ",", ".", 1), ".")
+	parts := strings.Split(clock, ":")
+
+	var ms int64
+	for _, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ms = ms*60 + n
+	}
+	ms *= 1000
+
+	// "5" is 500 ms, as in a decimal fraction; no fraction is 0 ms
+	frac = (frac + "000")[:3]
+	n, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ms + n, nil
+}
+
+// timestamp formats milliseconds as a WebVTT timestamp, HH:MM:SS.mmm
+func timestamp(ms int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,105 @@
+package subtitles
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToWebVTT(t *testing.T) {
+	const twoCues = "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\nHello\n\n2\n00:00:03.000 --> 00:00:04.000\nWorld\n"
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{
+			name: "srt",
+			in:   "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:04,000\nWorld\n",
+			want: twoCues,
+		},
+		{
+			name: "crlf and bom",
+			in:   "\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nWorld\r\n",
+			want: twoCues,
+		},
+		{
+			name: "blank line runs",
+			in:   "\n\n1\n00:00:01,000 --> 00:00:02,500\nHello\n\n\n\n\n2\n00:00:03,000 --> 00:00:04,000\nWorld\n\n\n",
+			want: twoCues,
+		},
+		{
+			name: "missing cue index",
+			in:   "00:00:01,000 --> 00:00:02,000\nHello\n",
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+		{
+			name: "non-numeric cue index",
+			in:   "intro\n00:00:01,000 --> 00:00:02,000\nHello\n",
+			want: "WEBVTT\n\nintro\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+		{
+			name: "dot and short milliseconds",
+			in:   "1\n01:02.5 --> 1:01:04,25\nHello\n",
+			want: "WEBVTT\n\n1\n00:01:02.500 --> 01:01:04.250\nHello\n",
+		},
+		{
+			name: "no milliseconds",
+			in:   "1\n00:00:01 --> 00:00:02\nHello\n",
+			want: "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+		{
+			name: "arrow in cue text",
+			in:   "1\n00:00:01,000 --> 00:00:02,000\nA --> B\n",
+			want: "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nA -> B\n",
+		},
+		{
+			name: "srt markup",
+			in:   "1\n00:00:01,000 --> 00:00:02,000\n{\\an8}<font color=\"red\">Hello</font> <i>there</i>\n",
+			want: "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nHello <i>there</i>\n",
+		},
+		{
+			name: "webvtt kept",
+			in:   "\xef\xbb\xbfWEBVTT - title\r\n\r\n00:01.000 --> 00:02.000 align:start\r\nHello\r\n",
+			want: "WEBVTT - title\n\n00:01.000 --> 00:02.000 align:start\nHello\n",
+		},
+		{name: "plain text", in: "just some text\n", err: ErrInvalid},
+		{name: "webvtt without cues", in: "WEBVTT\n\nNOTE nothing here\n", err: ErrInvalid},
+		{name: "empty", in: "", err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		got, err := ToWebVTT([]byte(tt.in))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"00:00:01,000", 1000, true},
+		{"00:00:01.000", 1000, true},
+		{"01:02:03,004", 3723004, true},
+		{"02:03.5", 123500, true},
+		{"00:00:01,05", 1050, true},
+		{"100:00:00.000", 360000000, true},
+		{"00:00:07", 7000, true},
+		{"00:xx:01,000", 0, false},
+		{"00:00:01,abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
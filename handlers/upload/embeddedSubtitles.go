@@ -0,0 +1,113 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"packetized-media-streaming/handlers/subtitles"
+)
+
+// textSubtitleCodecs are the subtitle codecs ffmpeg can convert to WebVTT.
+// Bitmap subtitles such as PGS and DVD need OCR and are left out.
+var textSubtitleCodecs = map[string]bool{
+	"subrip": true, "srt": true, "ass": true, "ssa": true,
+	"mov_text": true, "webvtt": true, "text": true,
+}
+
+// TextSubtitles returns the subtitle streams that can be converted to WebVTT
+func (m *VideoMetadata) TextSubtitles() []StreamMetadata {
+	var streams []StreamMetadata
+	for _, s := range m.Streams {
+		if s.CodecType == "subtitle" && textSubtitleCodecs[s.CodecName] {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// extractSubtitles converts the text subtitle streams of a source to WebVTT
+// in one ffmpeg run and adds them to the video's subtitle tracks, to be
+// published with its manifests. Tracks uploaded for the video keep their
+// names; an embedded track of the same language is named after its stream.
+func extractSubtitles(ctx context.Context, inputPath, videoID string, meta *VideoMetadata) error {
+	streams := meta.TextSubtitles()
+	if len(streams) == 0 {
+		return nil
+	}
+
+	dir := filepath.ToSlash(filepath.Join(localStorage, videoID+"_subtitles"))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"-y", "-i", inputPath}
+	for _, s := range streams {
+		args = append(args, "-map", fmt.Sprintf("0:%d", s.Index), "-c:s", "webvtt",
+			filepath.Join(dir, fmt.Sprintf("%d.vtt", s.Index)))
+	}
+	if err := runFFmpeg(ctx, args, 0, nil); err != nil {
+		return err
+	}
+
+	existing, err := subtitles.List(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, t := range existing {
+		// Tracks from an earlier encode of the source are replaced
+		if t.Source != subtitles.SourceEmbedded {
+			taken[t.Name] = true
+		}
+	}
+
+	for _, s := range streams {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.vtt", s.Index)))
+		if err != nil {
+			return err
+		}
+		vtt, err := subtitles.ToWebVTT(data)
+		if err != nil {
+			// A stream without cues has nothing to show
+			fmt.Printf("Warning: subtitle stream %d of video %s is empty\n", s.Index, videoID)
+			continue
+		}
+
+		track := subtitles.Subtitle{
+			VideoID:  videoID,
+			Language: s.language(),
+			Label:    s.Tags["title"],
+			Source:   subtitles.SourceEmbedded,
+			Default:  s.Disposition["default"] == 1,
+			Forced:   s.Disposition["forced"] == 1,
+		}
+		track.Name = strings.ToLower(track.Language)
+		if track.Name == "" {
+			track.Name = "track"
+		}
+		if track.Forced {
+			track.Name += "-forced"
+		}
+		if taken[track.Name] || track.Language == "" {
+			track.Name += "-" + strconv.Itoa(s.Index)
+		}
+		taken[track.Name] = true
+		if track.Label == "" {
+			track.Label = track.Language
+		}
+		if track.Label == "" {
+			track.Label = "Subtitles " + strconv.Itoa(s.Index)
+		}
+
+		if err := subtitles.Add(ctx, &track, vtt); err != nil {
+			return fmt.Errorf("storing subtitle stream %d: %w", s.Index, err)
+		}
+		fmt.Printf("Extracted subtitle stream %d of video %s as %s\n", s.Index, videoID, track.Name)
+	}
+	return nil
+}
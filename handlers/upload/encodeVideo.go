@@ -5,184 +5,236 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
-	"cloud.google.com/go/storage"
-	"google.golang.org/api/option"
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/subtitles"
+	"packetized-media-streaming/handlers/videos"
 )
 
-func processVideoFromGCS(videoId, BucketName, fileName string) {
-	// Construct the GCS object path
+// ProcessJob downloads the uploaded source of an encoding job and encodes it.
+// It is the jobs.Handler run by the encoding worker pool.
+func ProcessJob(ctx context.Context, job *jobs.Job) error {
+	videoId, fileName := job.VideoID, job.FileName
+
+	// Construct the object path
 	objectPath := fmt.Sprintf("videos/%s/%s", videoId, fileName)
 
-	// Initialize GCS Client
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx, option.WithCredentialsFile(credentialsFile))
-	if err != nil {
-		fmt.Printf("Failed to create GCS client: %v\n", err)
-		return
-	}
-	defer client.Close()
+	reportStep(ctx, job, StepDownload, 0)
 
-	// Check if the object exists
-	obj := client.Bucket(bucketName).Object(objectPath)
 	// Retry loop to check if the file exists every 10 seconds
+	var size int64
 	maxRetries := 5
 	retries := 0
-	var objAttrs *storage.ObjectAttrs
 	for retries < maxRetries {
 		// Check if the object exists
-		obj := client.Bucket(bucketName).Object(objectPath)
-		objAttrs, err = obj.Attrs(ctx)
+		objAttrs, err := objectstore.Default.Stat(ctx, objectPath)
 		if err != nil {
-			if err == storage.ErrObjectNotExist {
-				// If the object does not exist, retry after 10 seconds
-				fmt.Printf("Object %s does not exist in bucket %s. Retrying in 10 seconds...\n", objectPath, bucketName)
-			} else {
+			if err != objectstore.ErrNotExist {
 				// Handle other errors, such as network issues
-				fmt.Printf("Error retrieving object attributes: %v\n", err)
-				return
+				return fmt.Errorf("error retrieving object attributes: %w", err)
 			}
+			// If the object does not exist, retry after 10 seconds
+			fmt.Printf("Object %s does not exist. Retrying in 10 seconds...\n", objectPath)
 		} else {
 			// Object exists, log its attributes and break the retry loop
-			fmt.Printf("Object %s found in bucket %s\n", objectPath, bucketName)
-			fmt.Printf("Object attributes: Name=%s, Size=%d, ContentType=%s\n", objAttrs.Name, objAttrs.Size, objAttrs.ContentType)
+			fmt.Printf("Object %s found\n", objectPath)
+			fmt.Printf("Object attributes: Name=%s, Size=%d, ContentType=%s\n", objAttrs.Key, objAttrs.Size, objAttrs.ContentType)
+			size = objAttrs.Size
 			break
 		}
 
 		// Increment retry counter and wait for 10 seconds before retrying
 		retries++
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
 	}
 
 	if retries == maxRetries {
-		// If we reached the max retries, exit the function
-		fmt.Printf("Exceeded maximum retries (%d). Aborting...\n", maxRetries)
-		return
+		// If we reached the max retries, give up on the job
+		return fmt.Errorf("source %s not found after %d retries", objectPath, maxRetries)
 	}
 
-	// Download the file from GCS
-	rc, err := obj.NewReader(ctx)
+	// Download the file from the object store
+	rc, err := objectstore.Default.Get(ctx, objectPath)
 	if err != nil {
-		fmt.Printf("Failed to read file from GCS: %v\n", err)
-		return
+		return fmt.Errorf("failed to read file from storage: %w", err)
 	}
 	defer rc.Close()
 
 	// Create a "videos" directory if it doesn't exist
 	videoDir := filepath.Join("videos", videoId)
 	if err := os.MkdirAll(videoDir, os.ModePerm); err != nil {
-		fmt.Printf("Failed to create video directory: %v\n", err)
-		return
+		return fmt.Errorf("failed to create video directory: %w", err)
 	}
+	// Delete the working directory whatever the outcome
+	defer os.RemoveAll(videoDir)
 
 	// Save the video to the newly created folder
 	tempFilePath := filepath.Join(videoDir, fileName)
 	tempFile, err := os.Create(tempFilePath)
 	if err != nil {
-		fmt.Printf("Failed to create temp file: %v\n", err)
-		return
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer tempFile.Close()
 
-	// Copy the video content from GCS to the temp file
-	if _, err := io.Copy(tempFile, rc); err != nil {
-		fmt.Printf("Failed to copy video from GCS to temp file: %v\n", err)
-		return
+	// Copy the video content from storage to the temp file
+	if _, err := io.Copy(tempFile, &progressReader{ctx: ctx, job: job, r: countingReader{r: rc}, total: size}); err != nil {
+		return fmt.Errorf("failed to copy video from storage to temp file: %w", err)
 	}
 
 	// Process the video (encoding, etc.) using FFmpeg
-	EncodeVideo(tempFilePath, videoId)
-
-	// Delete the temporary file
-	if err := os.Remove(tempFilePath); err != nil {
-		fmt.Printf("Failed to delete temp file: %v\n", err)
-	}
+	return EncodeVideo(ctx, tempFilePath, job)
 }
 
-// Encode video into different qualities using FFmpeg
-func EncodeVideo(inputPath, videoID string) {
+// Encode video into different qualities using FFmpeg, recording progress on job
+func EncodeVideo(ctx context.Context, inputPath string, job *jobs.Job) error {
+	videoID := job.VideoID
+
 	// Convert to absolute path
 	absInputPath, err := filepath.Abs(inputPath)
 	if err != nil {
-		fmt.Printf("Error getting absolute path: %v\n", err)
-		return
+		return fmt.Errorf("error getting absolute path: %w", err)
 	}
 	inputPath = filepath.ToSlash(absInputPath)
 
-	hlsOutput := filepath.ToSlash(filepath.Join(localStorage, videoID+"_hls"))
-	dashOutput := filepath.ToSlash(filepath.Join(localStorage, videoID+"_dash"))
-
 	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-		fmt.Printf("Error: Input file does not exist: %s\n", inputPath)
-		return
-	}
-
-	fmt.Println("HLS Output Path:", hlsOutput)
-	fmt.Println("DASH Output Path:", dashOutput)
-
-	// Create Output Directories
-	os.MkdirAll(hlsOutput, os.ModePerm)
-	os.MkdirAll(dashOutput, os.ModePerm)
-
-	// FFmpeg command for HLS
-	hlsCmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-preset", "fast", "-g", "48", "-sc_threshold", "0",
-		"-map", "0:v:0", "-map", "0:a:0",
-		"-c:v", "libx264", "-crf", "23", "-profile:v", "main", "-c:a", "aac", "-ar", "48000", "-b:a", "128k",
-		"-b:v:0", "800k", "-s:v:0", "640x360",
-		"-hls_time", "10", "-hls_playlist_type", "vod",
-		"-hls_flags", "independent_segments",
-		"-hls_segment_filename", filepath.Join(hlsOutput, "segment_%03d.ts"),
-		filepath.Join(hlsOutput, "playlist.m3u8"),
-	)
-
-	// FFmpeg command for DASH
-	dashCmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-preset", "fast", "-g", "48", "-sc_threshold", "0",
-		"-r", "30", "-vsync", "cfr",
-		"-map", "0:v:0", "-map", "0:v:0", "-map", "0:v:0", "-map", "0:a:0",
-		"-c:v", "libx264", "-crf", "23", "-profile:v", "main", "-c:a", "aac", "-ar", "48000", "-b:a", "128k",
-		"-b:v:0", "800k", "-s:v:0", "640x360",
-		"-b:v:1", "1400k", "-s:v:1", "1280x720",
-		"-b:v:2", "2800k", "-s:v:2", "1920x1080",
-		"-f", "dash",
-		"-adaptation_sets", "id=0,streams=v id=1,streams=a",
-		"-seg_duration", "10", // 10 second segment duration
-		"-use_timeline", "1",
-		"-use_template", "1",
-		"-init_seg_name", "init-stream$RepresentationID$.m4s",
-		"-media_seg_name", "chunk-stream$RepresentationID$-$Number$.m4s",
-		filepath.ToSlash(filepath.Join(dashOutput, "manifest.mpd")),
-	)
-
-	// Capture output for debugging	hlsCmd.Stderr = os.Stderr
-	hlsCmd.Stdout = os.Stdout
-	dashCmd.Stderr = os.Stderr
-	dashCmd.Stdout = os.Stdout
-
-	// Run FFmpeg process
-	fmt.Println("Executing HLS Command:", hlsCmd.String())
-	if err := hlsCmd.Run(); err != nil {
-		fmt.Printf("HLS encoding failed: %v\n", err)
-		return
-	}
-
-	fmt.Println("Executing DASH Command:", dashCmd.String())
-	if err := dashCmd.Run(); err != nil {
-		fmt.Printf("DASH encoding failed: %v\n", err)
-		return
-	}
-
-	fmt.Println("Encoding completed for HLS & DASH")
-
-	// Upload HLS & DASH segment to GCS
-	UploadToGCS(hlsOutput, videoID, "HLS")
-	UploadToGCS(dashOutput, videoID, "DASH")
+		return fmt.Errorf("input file does not exist: %s", inputPath)
+	}
+
+	events.Publish(events.EncodingStarted, videoID, map[string]interface{}{"attempt": job.Attempts, "profile": job.Profile})
+
+	profile, err := lookupProfile(job.Profile)
+	if err != nil {
+		return err
+	}
+
+	// Record the source's media info, fit the ladder to it, and use its
+	// duration to turn ffmpeg progress into a percentage
+	var duration float64
+	meta, err := ProbeVideo(ctx, inputPath)
+	if err != nil {
+		fmt.Printf("Warning: could not probe %s, encoding the full ladder with unknown progress: %v\n", inputPath, err)
+		meta = nil
+		// Whether there is audio still decides how it is mapped. The HLS
+		// variant streams and CODECS must name it, so a source whose streams
+		// cannot be listed either is encoded as having none.
+		hasAudio, err := listsAudio(ctx, inputPath)
+		if err != nil {
+			fmt.Printf("Warning: could not list the streams of %s, encoding it without its audio: %v\n", inputPath, err)
+		}
+		profile = profile.forAudio(hasAudio)
+	} else {
+		if err := videos.SetMediaInfo(ctx, videoID, meta.MediaInfo()); err != nil {
+			fmt.Printf("Warning: failed to record media info of video %s: %v\n", videoID, err)
+		}
+		profile = profile.forSource(meta)
+		if duration = meta.Duration(); duration > 0 {
+			if err := videos.SetDuration(ctx, videoID, duration); err != nil {
+				fmt.Printf("Warning: failed to record duration of video %s: %v\n", videoID, err)
+			}
+		}
+	}
+	passes := profile.encodePasses(inputPath, videoID)
+
+	// Create Output Directories, removed again if encoding or upload fails
+	for _, pass := range passes {
+		fmt.Printf("%s Output Path: %s\n", pass.format, pass.dir)
+		os.MkdirAll(pass.dir, os.ModePerm)
+		defer os.RemoveAll(pass.dir)
+	}
+
+	// Run FFmpeg process, one rendition per ladder rung
+	for _, pass := range passes {
+		reportStep(ctx, job, pass.step, 0)
+		if err := runFFmpeg(ctx, pass.args, duration, encodeProgress(ctx, job, pass.step)); err != nil {
+			return fmt.Errorf("%s encoding failed: %w", pass.format, err)
+		}
+		if pass.finish != nil {
+			if err := pass.finish(pass.dir); err != nil {
+				return fmt.Errorf("%s packaging failed: %w", pass.format, err)
+			}
+		}
+	}
+
+	fmt.Printf("Encoding completed with profile %s (%d renditions)\n", profile.Name, len(profile.Ladder))
+
+	// Poster, thumbnails and seek-preview sprites; the video plays without
+	// them, so one that fails is left out instead of failing the job
+	reportStep(ctx, job, StepThumbnails, 0)
+	var stills []stillsPass
+	for _, s := range stillsPasses(ctx, inputPath, videoID, duration, meta) {
+		defer os.RemoveAll(s.dir)
+		err := os.MkdirAll(s.dir, os.ModePerm)
+		if err == nil {
+			err = s.extract(s.dir)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to extract %s of video %s: %v\n", s.format, videoID, err)
+			continue
+		}
+		stills = append(stills, s)
+	}
+
+	// Text subtitle streams of the source become subtitle tracks, added to
+	// the manifests once they are uploaded
+	if meta != nil {
+		if err := extractSubtitles(ctx, inputPath, videoID, meta); err != nil {
+			fmt.Printf("Warning: failed to extract subtitles of video %s: %v\n", videoID, err)
+		}
+	}
+
+	// Upload segments and manifests to storage
+	reportStep(ctx, job, StepUpload, 0)
+	total := 0
+	for _, pass := range passes {
+		total += countFiles(pass.dir)
+	}
+	for _, s := range stills {
+		total += countFiles(s.dir)
+	}
+	uploaded := 0
+	onUpload := func(objectPath string) {
+		uploaded++
+		reportStep(ctx, job, StepUpload, float64(uploaded)/float64(total))
+		events.Publish(events.SegmentUploaded, videoID, map[string]interface{}{
+			"object":   objectPath,
+			"uploaded": uploaded,
+			"total":    total,
+		})
+	}
+	for _, pass := range passes {
+		if err := UploadToStorage(ctx, pass.dir, videoID, pass.format, onUpload); err != nil {
+			return fmt.Errorf("%s upload failed: %w", pass.format, err)
+		}
+	}
+	for _, s := range stills {
+		if err := UploadToStorage(ctx, s.dir, videoID, s.format, onUpload); err != nil {
+			return fmt.Errorf("%s upload failed: %w", s.format, err)
+		}
+	}
+
+	// Record what was produced; the video is marked ready once the job finishes
+	var renditions []videos.Rendition
+	for _, pass := range passes {
+		for _, r := range profile.Ladder {
+			renditions = append(renditions, videos.Rendition{Format: pass.format, Width: r.Width, Height: r.Height, Bandwidth: profile.bandwidth(r)})
+		}
+	}
+	if err := videos.SetOutputs(ctx, videoID, manifests(profile.Packaging, videoID), renditions); err != nil {
+		return fmt.Errorf("failed to record outputs: %w", err)
+	}
+
+	// Fresh manifests list no subtitles; add the uploaded and embedded tracks
+	if err := subtitles.Publish(ctx, videoID); err != nil {
+		fmt.Printf("Warning: failed to publish subtitles of video %s: %v\n", videoID, err)
+	}
 
 	// Delete original file
 	if err := os.Remove(inputPath); err != nil {
@@ -190,4 +242,6 @@ func EncodeVideo(inputPath, videoID string) {
 	} else {
 		fmt.Printf("Deleted local file: %s\n", inputPath)
 	}
+
+	return nil
 }
@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EncodeProgress is one progress report parsed from ffmpeg's -progress output
+type EncodeProgress struct {
+	Frame   int64
+	OutTime time.Duration
+	// Speed is the encode speed as a multiple of real time (2 means twice as fast)
+	Speed float64
+	// Percent is OutTime against the source duration, 0 when the duration is unknown
+	Percent float64
+	// ETA is the remaining wall clock time at the current speed, 0 when unknown
+	ETA time.Duration
+}
+
+// runFFmpeg runs ffmpeg with args and reports progress against the source
+// duration in seconds. On failure the tail of ffmpeg's log is part of the error.
+func runFFmpeg(ctx context.Context, args []string, duration float64, onProgress func(EncodeProgress)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner", "-nostats", "-progress", "pipe:1"}, args...)...)
+
+	stderr := &tailBuffer{max: 4096}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Executing FFmpeg Command:", cmd.String())
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	parseProgress(stdout, duration, onProgress)
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// parseProgress reads key=value blocks, each ending with a progress= line
+func parseProgress(r io.Reader, duration float64, onProgress func(EncodeProgress)) {
+	var p EncodeProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_ms", "out_time_us":
+			// Despite its name out_time_ms is in microseconds as well
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			// An ETA from an earlier block is stale once the speed is N/A
+			p.ETA = 0
+			if duration > 0 {
+				done := p.OutTime.Seconds()
+				p.Percent = min(done/duration*100, 100)
+				if p.Speed > 0 {
+					p.ETA = time.Duration(max(duration-done, 0) / p.Speed * float64(time.Second))
+				}
+			}
+			if value == "end" {
+				p.Percent, p.ETA = 100, 0
+			}
+			if onProgress != nil {
+				onProgress(p)
+			}
+		}
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
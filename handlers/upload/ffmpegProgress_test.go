@@ -0,0 +1,114 @@
+package upload
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// progressTranscript is ffmpeg -progress pipe:1 output of a 10 second
+// source: nothing is encoded in the first block, and the speed is N/A in
+// the first and third
+const progressTranscript = `frame=0
+fps=0.00
+stream_0_0_q=0.0
+bitrate=N/A
+total_size=0
+out_time_us=N/A
+out_time_ms=N/A
+out_time=N/A
+dup_frames=0
+drop_frames=0
+speed=N/A
+progress=continue
+frame=48
+fps=47.52
+stream_0_0_q=28.0
+bitrate= 512.0kbits/s
+total_size=128000
+out_time_us=2000000
+out_time_ms=2000000
+out_time=00:00:02.000000
+dup_frames=0
+drop_frames=0
+speed=4x
+progress=continue
+frame=120
+fps=46.10
+stream_0_0_q=28.0
+bitrate= 510.3kbits/s
+total_size=319000
+out_time_us=5000000
+out_time_ms=5000000
+out_time=00:00:05.000000
+dup_frames=0
+drop_frames=0
+speed=N/A
+progress=continue
+frame=240
+fps=48.00
+stream_0_0_q=-1.0
+bitrate= 508.9kbits/s
+total_size=636000
+out_time_us=10000000
+out_time_ms=10000000
+out_time=00:00:10.000000
+dup_frames=0
+drop_frames=0
+speed=5.0x
+progress=end
+`
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		want     []EncodeProgress
+	}{
+		{
+			name:     "known duration",
+			duration: 10,
+			want: []EncodeProgress{
+				{},
+				{Frame: 48, OutTime: 2 * time.Second, Speed: 4, Percent: 20, ETA: 2 * time.Second},
+				{Frame: 120, OutTime: 5 * time.Second, Percent: 50},
+				{Frame: 240, OutTime: 10 * time.Second, Speed: 5, Percent: 100},
+			},
+		},
+		{
+			name:     "unknown duration",
+			duration: 0,
+			want: []EncodeProgress{
+				{},
+				{Frame: 48, OutTime: 2 * time.Second, Speed: 4},
+				{Frame: 120, OutTime: 5 * time.Second},
+				{Frame: 240, OutTime: 10 * time.Second, Speed: 5, Percent: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		var got []EncodeProgress
+		parseProgress(strings.NewReader(progressTranscript), tt.duration, func(p EncodeProgress) {
+			got = append(got, p)
+		})
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: %d reports, want %d", tt.name, len(got), len(tt.want))
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: report %d is %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseProgressOverrun(t *testing.T) {
+	// The probed duration can be shorter than what ffmpeg writes
+	var last EncodeProgress
+	parseProgress(strings.NewReader("out_time_us=12000000\nspeed=2x\nprogress=continue\n"), 10, func(p EncodeProgress) {
+		last = p
+	})
+	if last.Percent != 100 || last.ETA != 0 {
+		t.Errorf("progress past the duration: %+v, want 100%% and no ETA", last)
+	}
+}
@@ -10,9 +10,8 @@ import (
 
 // Video metadata struct to parse JSON Output
 type VideoMetadata struct {
-	Format struct {
-		Duration string `json:"duration"`
-	}	`json:"format"`
+	Format  FormatMetadata   `json:"format"`
+	Streams []StreamMetadata `json:"streams"`
 }
 
 func GetVideoDuration(filePath string) (float64, error) {
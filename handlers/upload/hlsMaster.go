@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Master playlists served to HLS players
+const (
+	hlsMasterName  = "playlist.m3u8"
+	cmafMasterName = "master.m3u8"
+)
+
+// hlsVariant is a rung's media playlist within an output directory
+type hlsVariant struct {
+	playlist string
+	rung     Rung
+}
+
+// writeHLSMaster writes the master playlist of the MPEG-TS encode, whose
+// variant playlists <name>.m3u8 each carry their own audio
+func (p *Profile) writeHLSMaster(outputDir string) error {
+	variants := make([]hlsVariant, len(p.Ladder))
+	for i, r := range p.Ladder {
+		variants[i] = hlsVariant{playlist: r.Name + ".m3u8", rung: r}
+	}
+	// I-frame playlists need version 4
+	version := 3
+	if p.TrickPlay {
+		version = 4
+	}
+	return p.writeMasterPlaylist(filepath.Join(outputDir, hlsMasterName), version, variants, "")
+}
+
+// writeCMAFMaster replaces the master playlist the dash muxer writes with one
+// carrying CODECS. The muxer names media playlists by output stream, so the
+// rungs are media_0.m3u8 onwards and the audio, if any, follows them.
+func (p *Profile) writeCMAFMaster(outputDir string) error {
+	variants := make([]hlsVariant, len(p.Ladder))
+	for i, r := range p.Ladder {
+		variants[i] = hlsVariant{playlist: fmt.Sprintf("media_%d.m3u8", i), rung: r}
+	}
+	audio := ""
+	if p.hasAudio() {
+		audio = fmt.Sprintf("media_%d.m3u8", len(p.Ladder))
+	}
+	return p.writeMasterPlaylist(filepath.Join(outputDir, cmafMasterName), 7, variants, audio)
+}
+
+// writeMasterPlaylist lists every variant. BANDWIDTH is the peak and
+// AVERAGE-BANDWIDTH the mean bitrate of the variant's segments as actually
+// encoded, plus those of the separate audio playlist when there is one, so
+// players pick rungs by what they will really download.
+func (p *Profile) writeMasterPlaylist(path string, version int, variants []hlsVariant, audioPlaylist string) error {
+	dir := filepath.Dir(path)
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	var audioPeak, audioAverage int
+	audioGroup := ""
+	if audioPlaylist != "" {
+		var err error
+		audioPeak, audioAverage, err = variantBitrates(dir, audioPlaylist)
+		if err != nil {
+			return fmt.Errorf("measuring %s: %w", audioPlaylist, err)
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio\",DEFAULT=YES,AUTOSELECT=YES,URI=\"%s\"\n", audioPlaylist)
+		audioGroup = ",AUDIO=\"audio\""
+	}
+
+	for _, v := range variants {
+		peak, average, err := variantBitrates(dir, v.playlist)
+		if err != nil {
+			return fmt.Errorf("measuring %s: %w", v.playlist, err)
+		}
+
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"%s\n",
+			peak+audioPeak, average+audioAverage, v.rung.Width, v.rung.Height, p.codecs(v.rung), audioGroup)
+		b.WriteString(v.playlist + "\n")
+	}
+
+	if p.TrickPlay {
+		entry, err := p.iframeStreamInf(dir)
+		if err != nil {
+			return err
+		}
+		b.WriteString(entry)
+	}
+
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// variantBitrates reads a media playlist and the sizes of its segments, or
+// of their byte ranges, and returns the peak and average segment bitrate in
+// bits per second
+func variantBitrates(dir, playlist string) (peak, average int, err error) {
+	f, err := os.Open(filepath.Join(dir, playlist))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var duration, totalDuration float64
+	var totalBits float64
+	byteRange := int64(-1)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("bad segment duration %q", value)
+			}
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			length, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), "@")
+			byteRange, err = strconv.ParseInt(length, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("bad byte range %q", line)
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			size := byteRange
+			if size < 0 {
+				info, err := os.Stat(filepath.Join(dir, line))
+				if err != nil {
+					return 0, 0, err
+				}
+				size = info.Size()
+			}
+			byteRange = -1
+			bits := float64(size * 8)
+			if duration > 0 {
+				peak = max(peak, int(bits/duration))
+			}
+			totalBits += bits
+			totalDuration += duration
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if totalDuration == 0 {
+		return 0, 0, fmt.Errorf("no segments")
+	}
+	return peak, int(totalBits / totalDuration), nil
+}
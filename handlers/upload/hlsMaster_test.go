@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFixtures writes files of a test output directory; a segment is
+// given by its size and written as that many zero bytes
+func writeFixtures(t *testing.T, dir string, playlists map[string]string, segments map[string]int) {
+	t.Helper()
+	for name, body := range playlists {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, size := range segments {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const (
+	// 400 and 600 kbps segments
+	playlist360p = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n" +
+		"#EXTINF:4.000000,\n360p_000.ts\n#EXTINF:2.000000,\n360p_001.ts\n#EXT-X-ENDLIST\n"
+	// 1000 and 1200 kbps segments
+	playlist720p = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n" +
+		"#EXTINF:4.000000,\n720p_000.ts\n#EXTINF:2.000000,\n720p_001.ts\n#EXT-X-ENDLIST\n"
+	// 80, 120 and 40 kbps byte ranges of one file, the last one following
+	// on from the previous range
+	playlistIFrames = "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-TARGETDURATION:1\n#EXT-X-I-FRAMES-ONLY\n" +
+		"#EXTINF:1.000000,\n#EXT-X-BYTERANGE:10000@0\niframes.ts\n" +
+		"#EXTINF:1.000000,\n#EXT-X-BYTERANGE:15000@10000\niframes.ts\n" +
+		"#EXTINF:1.000000,\n#EXT-X-BYTERANGE:5000\niframes.ts\n#EXT-X-ENDLIST\n"
+)
+
+var fixtureSegments = map[string]int{
+	"360p_000.ts": 200000, "360p_001.ts": 150000,
+	"720p_000.ts": 500000, "720p_001.ts": 300000,
+	// Byte ranges are measured, not the file they point into
+	"iframes.ts": 1,
+}
+
+func testLadder() []Rung {
+	return []Rung{
+		{Name: "360p", Width: 640, Height: 360, BitrateKbps: 800},
+		{Name: "720p", Width: 1280, Height: 720, BitrateKbps: 2500},
+	}
+}
+
+func TestVariantBitrates(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, map[string]string{
+		"360p.m3u8":    playlist360p,
+		"iframes.m3u8": playlistIFrames,
+		"empty.m3u8":   "#EXTM3U\n#EXT-X-ENDLIST\n",
+		"missing.m3u8": "#EXTM3U\n#EXTINF:4.000000,\nmissing_000.ts\n",
+	}, fixtureSegments)
+
+	tests := []struct {
+		playlist      string
+		peak, average int
+		ok            bool
+	}{
+		{"360p.m3u8", 600000, 466666, true},
+		{"iframes.m3u8", 120000, 80000, true},
+		{"empty.m3u8", 0, 0, false},
+		{"missing.m3u8", 0, 0, false},
+		{"absent.m3u8", 0, 0, false},
+	}
+	for _, tt := range tests {
+		peak, average, err := variantBitrates(dir, tt.playlist)
+		if (err == nil) != tt.ok || peak != tt.peak || average != tt.average {
+			t.Errorf("variantBitrates(%s) = %d, %d, %v; want %d, %d", tt.playlist, peak, average, err, tt.peak, tt.average)
+		}
+	}
+}
+
+func TestWriteHLSMaster(t *testing.T) {
+	dir := t.TempDir()
+	writeFixtures(t, dir, map[string]string{
+		"360p.m3u8":    playlist360p,
+		"720p.m3u8":    playlist720p,
+		"iframes.m3u8": playlistIFrames,
+	}, fixtureSegments)
+
+	p := &Profile{H264Profile: "main", FrameRate: 30, TrickPlay: true, Ladder: testLadder(), audio: audioNone}
+	if err := p.writeHLSMaster(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:4",
+		"#EXT-X-INDEPENDENT-SEGMENTS",
+		`#EXT-X-STREAM-INF:BANDWIDTH=600000,AVERAGE-BANDWIDTH=466666,RESOLUTION=640x360,CODECS="avc1.4d401e"`,
+		"360p.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=1200000,AVERAGE-BANDWIDTH=1066666,RESOLUTION=1280x720,CODECS="avc1.4d401f"`,
+		"720p.m3u8",
+		`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=120000,AVERAGE-BANDWIDTH=80000,RESOLUTION=640x360,CODECS="avc1.4d401e",URI="iframes.m3u8"`,
+		"",
+	}, "\n")
+	got, err := os.ReadFile(filepath.Join(dir, hlsMasterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("master playlist:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteCMAFMaster(t *testing.T) {
+	dir := t.TempDir()
+	// The audio playlist is in byte ranges of one file: 128 kbps
+	audio := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:4\n" +
+		"#EXTINF:4.000000,\n#EXT-X-BYTERANGE:64000@0\nmedia_2.mp4\n" +
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:32000@64000\nmedia_2.mp4\n#EXT-X-ENDLIST\n"
+	writeFixtures(t, dir, map[string]string{
+		"media_0.m3u8": strings.ReplaceAll(playlist360p, "360p_", "media_0_"),
+		"media_1.m3u8": strings.ReplaceAll(playlist720p, "720p_", "media_1_"),
+		"media_2.m3u8": audio,
+	}, map[string]int{
+		"media_0_000.ts": 200000, "media_0_001.ts": 150000,
+		"media_1_000.ts": 500000, "media_1_001.ts": 300000,
+		"media_2.mp4": 96000,
+	})
+
+	p := &Profile{H264Profile: "high", FrameRate: 30, Ladder: testLadder(), audio: audioPresent}
+	if err := p.writeCMAFMaster(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:7",
+		"#EXT-X-INDEPENDENT-SEGMENTS",
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="audio",DEFAULT=YES,AUTOSELECT=YES,URI="media_2.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=728000,AVERAGE-BANDWIDTH=594666,RESOLUTION=640x360,CODECS="avc1.64001e,mp4a.40.2",AUDIO="audio"`,
+		"media_0.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=1328000,AVERAGE-BANDWIDTH=1194666,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2",AUDIO="audio"`,
+		"media_1.m3u8",
+		"",
+	}, "\n")
+	got, err := os.ReadFile(filepath.Join(dir, cmafMasterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("master playlist:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,286 @@
+package upload
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Audio layouts of a source, set by forSource or forAudio
+const (
+	audioPresent = "present"
+	audioNone    = "none"
+	audioSilent  = "silent"
+)
+
+// h264Levels lists H.264 levels (times ten) with their maximum frame size
+// in macroblocks and macroblocks per second, from Table A-1 of the spec.
+// Levels below 3.0 are skipped so small rungs keep a widely supported level.
+var h264Levels = []struct {
+	level, maxFrame, maxRate int
+}{
+	{30, 1620, 40500},
+	{31, 3600, 108000},
+	{32, 5120, 216000},
+	{40, 8192, 245760},
+	{42, 8704, 522240},
+	{50, 22080, 589824},
+	{51, 36864, 983040},
+	{52, 36864, 2073600},
+}
+
+// level returns the lowest H.264 level, times ten, that fits the rung at fps
+func (r Rung) level(fps float64) int {
+	frame := ((r.Width + 15) / 16) * ((r.Height + 15) / 16)
+	for _, l := range h264Levels {
+		if frame <= l.maxFrame && float64(frame)*fps <= float64(l.maxRate) {
+			return l.level
+		}
+	}
+	return h264Levels[len(h264Levels)-1].level
+}
+
+// forSource adapts the profile to a probed source. Rungs taller than the
+// source are dropped so nothing is upscaled, and the remaining ones keep the
+// source's aspect ratio, with a rung's height applying to the shorter side of
+// portrait video. The source frame rate is kept, lowered to max_frame_rate
+// when the profile sets one.
+//
+// A source without audio gets a silent track when the profile asks for one
+// and is encoded video-only otherwise.
+func (p *Profile) forSource(meta *VideoMetadata) *Profile {
+	adapted := *p.forAudio(meta.HasAudio())
+	adapted.video = meta.VideoMap()
+
+	video := meta.VideoStream()
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return &adapted
+	}
+	srcWidth, srcHeight := video.FrameSize()
+	short := min(srcWidth, srcHeight)
+
+	adapted.Ladder = nil
+	for _, r := range p.Ladder {
+		if r.Height <= short {
+			adapted.Ladder = append(adapted.Ladder, r.fit(srcWidth, srcHeight, r.Height))
+		}
+	}
+	if len(adapted.Ladder) == 0 {
+		// Smaller than every rung: a single rendition at the source size
+		r := p.Ladder[0]
+		r.Name = fmt.Sprintf("%dp", short&^1)
+		adapted.Ladder = []Rung{r.fit(srcWidth, srcHeight, short&^1)}
+	}
+
+	if rate, fps := video.FrameRate(); fps > 0 {
+		adapted.sourceRate, adapted.sourceFPS = rate, fps
+		if p.MaxFrameRate > 0 && fps > float64(p.MaxFrameRate) {
+			adapted.sourceRate, adapted.sourceFPS = strconv.Itoa(p.MaxFrameRate), float64(p.MaxFrameRate)
+		}
+	}
+	return &adapted
+}
+
+// forAudio sets the audio layout of a source that has audio or not: its
+// own track, a silent one if the profile asks for it, or none
+func (p *Profile) forAudio(hasAudio bool) *Profile {
+	adapted := *p
+	switch {
+	case hasAudio:
+		adapted.audio = audioPresent
+	case p.SilentAudio:
+		adapted.audio = audioSilent
+	default:
+		adapted.audio = audioNone
+	}
+	return &adapted
+}
+
+// fit sizes the rung so its shorter side is size pixels and the other side
+// keeps the source aspect ratio, rounded to an even number like ffmpeg's
+// scale=-2 does
+func (r Rung) fit(srcWidth, srcHeight, size int) Rung {
+	if srcHeight > srcWidth {
+		r.Width, r.Height = size, evenScale(size, srcHeight, srcWidth)
+	} else {
+		r.Width, r.Height = evenScale(size, srcWidth, srcHeight), size
+	}
+	return r
+}
+
+func evenScale(size, num, den int) int {
+	return max(int(math.Round(float64(size)*float64(num)/float64(den)/2))*2, 2)
+}
+
+// fps is the output frame rate in frames per second
+func (p *Profile) fps() float64 {
+	if p.sourceFPS > 0 {
+		return p.sourceFPS
+	}
+	return float64(p.FrameRate)
+}
+
+// rate is the output frame rate as passed to -r
+func (p *Profile) rate() string {
+	if p.sourceRate != "" {
+		return p.sourceRate
+	}
+	return strconv.Itoa(p.FrameRate)
+}
+
+// gop is the keyframe interval in frames. The profile's gop is given at its
+// frame_rate, so it is scaled to keep the same interval in seconds.
+func (p *Profile) gop() int {
+	if p.sourceFPS > 0 {
+		return max(int(math.Round(float64(p.GOP)*p.sourceFPS/float64(p.FrameRate))), 1)
+	}
+	return p.GOP
+}
+
+// hasAudio reports whether the outputs carry an audio track
+func (p *Profile) hasAudio() bool {
+	return p.audio != audioNone
+}
+
+// codecs is the RFC 6381 codecs string of a rung: H.264 in the profile's
+// H.264 profile at the rung's level plus AAC-LC when there is audio
+func (p *Profile) codecs(r Rung) string {
+	codecs := fmt.Sprintf("avc1.%s%02x", h264Profiles[p.H264Profile], r.level(p.fps()))
+	if p.hasAudio() {
+		codecs += ",mp4a.40.2"
+	}
+	return codecs
+}
+
+// bandwidth is the nominal bitrate of a rung with its audio in bits per second
+func (p *Profile) bandwidth(r Rung) int {
+	if !p.hasAudio() {
+		return r.BitrateKbps * 1000
+	}
+	return (r.BitrateKbps + p.AudioBitrateKbps) * 1000
+}
+
+// videoArgs are the per-output-stream scaling and rate control options of
+// rung r as video output stream i. Rungs are sized to the displayed aspect
+// ratio, so the output has square pixels. CRF keeps quality constant while maxrate
+// bounds the bitrate players plan for.
+func (p *Profile) videoArgs(r Rung, i int) []string {
+	return []string{
+		fmt.Sprintf("-filter:v:%d", i), fmt.Sprintf("scale=%d:%d,setsar=1", r.Width, r.Height),
+		fmt.Sprintf("-maxrate:v:%d", i), strconv.Itoa(r.BitrateKbps) + "k",
+		fmt.Sprintf("-bufsize:v:%d", i), strconv.Itoa(2*r.BitrateKbps) + "k",
+		fmt.Sprintf("-level:v:%d", i), strconv.Itoa(r.level(p.fps())),
+	}
+}
+
+// inputArgs are the input, keyframe and frame rate options shared by every
+// encode. A silent track is generated as a second input, cut to the video's
+// length by -shortest.
+func (p *Profile) inputArgs(inputPath string) []string {
+	args := []string{"-i", inputPath}
+	if p.audio == audioSilent {
+		args = append(args,
+			"-f", "lavfi", "-i", fmt.Sprintf("anullsrc=channel_layout=stereo:sample_rate=%d", p.AudioSampleRate),
+			"-shortest",
+		)
+	}
+	return append(args,
+		"-preset", p.Preset, "-g", strconv.Itoa(p.gop()), "-sc_threshold", "0",
+		"-r", p.rate(), "-vsync", "cfr",
+	)
+}
+
+// videoMap is the -map specifier of the source video stream
+func (p *Profile) videoMap() string {
+	if p.video != "" {
+		return p.video
+	}
+	return "0:v:0"
+}
+
+// audioMap is the -map specifier of the audio track
+func (p *Profile) audioMap() string {
+	if p.audio == audioSilent {
+		return "1:a:0"
+	}
+	return "0:a:0"
+}
+
+// codecArgs are the encoder options shared by every rendition. Output is
+// 8-bit 4:2:0 whatever the source, as the H.264 profiles and CODECS require.
+func (p *Profile) codecArgs() []string {
+	args := []string{"-c:v", "libx264", "-pix_fmt", "yuv420p", "-crf", strconv.Itoa(p.CRF), "-profile:v", p.H264Profile}
+	if p.hasAudio() {
+		args = append(args, "-c:a", "aac", "-ar", strconv.Itoa(p.AudioSampleRate), "-b:a", strconv.Itoa(p.AudioBitrateKbps)+"k")
+	}
+	return args
+}
+
+// hlsArgs encodes every rung as its own variant playlist <name>.m3u8
+// with segments <name>_NNN.ts, each variant carrying its own audio
+func (p *Profile) hlsArgs(inputPath, outputDir string) []string {
+	args := p.inputArgs(inputPath)
+	for range p.Ladder {
+		args = append(args, "-map", p.videoMap())
+	}
+	if p.hasAudio() {
+		for range p.Ladder {
+			args = append(args, "-map", p.audioMap())
+		}
+	}
+	args = append(args, p.codecArgs()...)
+
+	streams := make([]string, len(p.Ladder))
+	for i, r := range p.Ladder {
+		args = append(args, p.videoArgs(r, i)...)
+		if p.hasAudio() {
+			streams[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name)
+		} else {
+			streams[i] = fmt.Sprintf("v:%d,name:%s", i, r.Name)
+		}
+	}
+
+	return append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(p.SegmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-var_stream_map", strings.Join(streams, " "),
+		"-hls_segment_filename", filepath.Join(outputDir, "%v_%03d.ts"),
+		filepath.Join(outputDir, "%v.m3u8"),
+	)
+}
+
+// dashArgs encodes every rung into one manifest.mpd with a shared audio
+// track. With hlsPlaylists the muxer also writes HLS media playlists that use
+// the same fragmented MP4 segments, making the output CMAF.
+func (p *Profile) dashArgs(inputPath, outputDir string, hlsPlaylists bool) []string {
+	args := p.inputArgs(inputPath)
+	for range p.Ladder {
+		args = append(args, "-map", p.videoMap())
+	}
+	adaptationSets := "id=0,streams=v"
+	if p.hasAudio() {
+		args = append(args, "-map", p.audioMap())
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args, p.codecArgs()...)
+	for i, r := range p.Ladder {
+		args = append(args, p.videoArgs(r, i)...)
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-adaptation_sets", adaptationSets,
+		"-seg_duration", strconv.Itoa(p.SegmentSeconds),
+		"-use_timeline", "1",
+		"-use_template", "1",
+		"-init_seg_name", "init-stream$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-stream$RepresentationID$-$Number$.m4s",
+	)
+	if hlsPlaylists {
+		args = append(args, "-hls_playlist", "1")
+	}
+	return append(args, filepath.ToSlash(filepath.Join(outputDir, "manifest.mpd")))
+}
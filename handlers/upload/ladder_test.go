@@ -0,0 +1,59 @@
+package upload
+
+import (
+	"strings"
+	"testing"
+)
+
+// argAfter is the value following flag in args, "" when flag is absent
+func argAfter(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestHLSArgsAudio(t *testing.T) {
+	base := &Profile{H264Profile: "main", FrameRate: 30, GOP: 60, SegmentSeconds: 6, Ladder: testLadder(),
+		AudioSampleRate: 48000, AudioBitrateKbps: 128}
+	silent := *base
+	silent.SilentAudio = true
+
+	tests := []struct {
+		name      string
+		profile   *Profile
+		audioMap  string
+		streamMap string
+		codecs    string
+	}{
+		{"source audio", base.forAudio(true), "0:a:0", "v:0,a:0,name:360p v:1,a:1,name:720p", "avc1.4d401e,mp4a.40.2"},
+		{"silent track", silent.forAudio(false), "1:a:0", "v:0,a:0,name:360p v:1,a:1,name:720p", "avc1.4d401e,mp4a.40.2"},
+		// Also a source whose streams could not be listed
+		{"no audio", base.forAudio(false), "", "v:0,name:360p v:1,name:720p", "avc1.4d401e"},
+	}
+	for _, tt := range tests {
+		args := tt.profile.hlsArgs("in.mp4", "out")
+		var audioMaps []string
+		for i := 0; i+1 < len(args); i++ {
+			if args[i] == "-map" && strings.Contains(args[i+1], ":a:") {
+				audioMaps = append(audioMaps, args[i+1])
+			}
+		}
+		if tt.audioMap == "" && len(audioMaps) > 0 || tt.audioMap != "" && len(audioMaps) != len(testLadder()) {
+			t.Errorf("%s: audio maps %v", tt.name, audioMaps)
+		}
+		for _, m := range audioMaps {
+			if m != tt.audioMap {
+				t.Errorf("%s: audio mapped as %s, want %s", tt.name, m, tt.audioMap)
+			}
+		}
+		if got := argAfter(args, "-var_stream_map"); got != tt.streamMap {
+			t.Errorf("%s: -var_stream_map %q, want %q", tt.name, got, tt.streamMap)
+		}
+		if got := tt.profile.codecs(tt.profile.Ladder[0]); got != tt.codecs {
+			t.Errorf("%s: CODECS %q, want %q", tt.name, got, tt.codecs)
+		}
+	}
+}
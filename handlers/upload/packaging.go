@@ -0,0 +1,100 @@
+package upload
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"packetized-media-streaming/handlers/videos"
+)
+
+// Packaging modes of an encoding profile
+const (
+	// PackagingSeparate encodes twice: MPEG-TS for HLS and fragmented MP4 for DASH
+	PackagingSeparate = "separate"
+	// PackagingCMAF encodes once into CMAF segments shared by an HLS
+	// playlist and a DASH manifest, stored under videos/<id>/CMAF
+	PackagingCMAF = "cmaf"
+)
+
+// encodePass is one ffmpeg run and the folder its output is stored in
+type encodePass struct {
+	step   string
+	format string
+	dir    string
+	args   []string
+	// finish, if not nil, runs after ffmpeg to write files it does not
+	finish func(dir string) error
+}
+
+// encodePasses returns the ffmpeg runs producing a video's streaming formats
+func (p *Profile) encodePasses(inputPath, videoID string) []encodePass {
+	if p.Packaging == PackagingCMAF {
+		dir := filepath.ToSlash(filepath.Join(localStorage, videoID+"_cmaf"))
+		args := p.dashArgs(inputPath, dir, true)
+		if p.TrickPlay {
+			args = append(args, p.iframeArgs(dir, true)...)
+			args = append(args, p.trickDASHArgs(dir)...)
+		}
+		return []encodePass{
+			{step: StepCMAFEncode, format: "CMAF", dir: dir, args: args, finish: p.finishCMAF},
+		}
+	}
+
+	hlsOutput := filepath.ToSlash(filepath.Join(localStorage, videoID+"_hls"))
+	dashOutput := filepath.ToSlash(filepath.Join(localStorage, videoID+"_dash"))
+	hlsArgs, dashArgs := p.hlsArgs(inputPath, hlsOutput), p.dashArgs(inputPath, dashOutput, false)
+	if p.TrickPlay {
+		hlsArgs = append(hlsArgs, p.iframeArgs(hlsOutput, false)...)
+		dashArgs = append(dashArgs, p.trickDASHArgs(dashOutput)...)
+	}
+	return []encodePass{
+		{step: StepHLSEncode, format: "HLS", dir: hlsOutput, args: hlsArgs, finish: p.finishHLS},
+		{step: StepDASHEncode, format: "DASH", dir: dashOutput, args: dashArgs, finish: p.finishDASH},
+	}
+}
+
+// finishHLS writes the master playlist of the MPEG-TS encode
+func (p *Profile) finishHLS(dir string) error {
+	if p.TrickPlay {
+		if err := finishIFrames(dir); err != nil {
+			return err
+		}
+	}
+	return p.writeHLSMaster(dir)
+}
+
+// finishDASH adds the trick mode adaptation set to the manifest
+func (p *Profile) finishDASH(dir string) error {
+	if p.TrickPlay {
+		return p.spliceTrickMode(dir)
+	}
+	return nil
+}
+
+// finishCMAF does both for the shared CMAF output
+func (p *Profile) finishCMAF(dir string) error {
+	if p.TrickPlay {
+		if err := finishIFrames(dir); err != nil {
+			return err
+		}
+		if err := p.spliceTrickMode(dir); err != nil {
+			return err
+		}
+	}
+	return p.writeCMAFMaster(dir)
+}
+
+// manifests returns the object keys of the HLS and DASH manifests a
+// packaging mode produces for a video
+func manifests(packaging, videoID string) []videos.Format {
+	if packaging == PackagingCMAF {
+		return []videos.Format{
+			{Name: "HLS", Manifest: fmt.Sprintf("videos/%s/CMAF/%s", videoID, cmafMasterName)},
+			{Name: "DASH", Manifest: fmt.Sprintf("videos/%s/CMAF/manifest.mpd", videoID)},
+		}
+	}
+	return []videos.Format{
+		{Name: "HLS", Manifest: fmt.Sprintf("videos/%s/HLS/%s", videoID, hlsMasterName)},
+		{Name: "DASH", Manifest: fmt.Sprintf("videos/%s/DASH/manifest.mpd", videoID)},
+	}
+}
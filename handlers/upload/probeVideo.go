@@ -0,0 +1,321 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"packetized-media-streaming/handlers/videos"
+)
+
+// FormatMetadata is the container part of ffprobe's -show_format output
+type FormatMetadata struct {
+	FormatName     string            `json:"format_name"`
+	FormatLongName string            `json:"format_long_name"`
+	Duration       string            `json:"duration"`
+	BitRate        string            `json:"bit_rate"`
+	Size           string            `json:"size"`
+	Tags           map[string]string `json:"tags"`
+}
+
+// StreamMetadata is one stream of ffprobe's -show_streams output
+type StreamMetadata struct {
+	Index              int               `json:"index"`
+	CodecType          string            `json:"codec_type"`
+	CodecName          string            `json:"codec_name"`
+	Profile            string            `json:"profile"`
+	Level              int               `json:"level"`
+	Width              int               `json:"width"`
+	Height             int               `json:"height"`
+	RFrameRate         string            `json:"r_frame_rate"`
+	AvgFrameRate       string            `json:"avg_frame_rate"`
+	SampleAspectRatio  string            `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string            `json:"display_aspect_ratio"`
+	BitRate            string            `json:"bit_rate"`
+	PixFmt             string            `json:"pix_fmt"`
+	BitsPerRawSample   string            `json:"bits_per_raw_sample"`
+	ColorRange         string            `json:"color_range"`
+	ColorSpace         string            `json:"color_space"`
+	ColorTransfer      string            `json:"color_transfer"`
+	ColorPrimaries     string            `json:"color_primaries"`
+	Channels           int               `json:"channels"`
+	ChannelLayout      string            `json:"channel_layout"`
+	SampleRate         string            `json:"sample_rate"`
+	Disposition        map[string]int    `json:"disposition"`
+	Tags               map[string]string `json:"tags"`
+	SideDataList       []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// ProbeVideo reads the format and streams of a media file with ffprobe
+func ProbeVideo(ctx context.Context, filePath string) (*VideoMetadata, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", filePath)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to execute ffprobe: %w", err)
+	}
+
+	var metadata VideoMetadata
+	if err := json.Unmarshal(out.Bytes(), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return &metadata, nil
+}
+
+// listsAudio reports whether ffmpeg lists an audio stream in a media file.
+// It stands in for ProbeVideo when ffprobe is missing or fails.
+func listsAudio(ctx context.Context, filePath string) (bool, error) {
+	// Without an output ffmpeg exits with an error after listing the streams
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-i", filePath).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) || ctx.Err() != nil {
+		return false, fmt.Errorf("failed to execute ffmpeg: %w", err)
+	}
+
+	listed := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, "Stream #") {
+			listed = true
+			if strings.Contains(line, ": Audio:") {
+				return true, nil
+			}
+		}
+	}
+	if !listed {
+		return false, fmt.Errorf("ffmpeg listed no streams in %s", filePath)
+	}
+	return false, nil
+}
+
+// Duration is the container duration in seconds, 0 when unknown
+func (m *VideoMetadata) Duration() float64 {
+	d, _ := strconv.ParseFloat(m.Format.Duration, 64)
+	return d
+}
+
+// VideoStream returns the first video stream, nil for audio-only files
+func (m *VideoMetadata) VideoStream() *StreamMetadata {
+	for i := range m.Streams {
+		if m.Streams[i].CodecType == "video" && !m.Streams[i].coverArt() {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// VideoMap is the -map specifier of VideoStream, so ffmpeg encodes the
+// stream the ladder was sized from and not cover art listed before it.
+// Without a probe it falls back to the first video stream.
+func (m *VideoMetadata) VideoMap() string {
+	if m == nil || m.VideoStream() == nil {
+		return "0:v:0"
+	}
+	return fmt.Sprintf("0:%d", m.VideoStream().Index)
+}
+
+// HasAudio reports whether the file has an audio stream
+func (m *VideoMetadata) HasAudio() bool {
+	for _, s := range m.Streams {
+		if s.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaInfo converts the probe output into the model stored with the video
+func (m *VideoMetadata) MediaInfo() *videos.MediaInfo {
+	info := &videos.MediaInfo{
+		Container: videos.Container{
+			Format:     m.Format.FormatLongName,
+			FormatName: m.Format.FormatName,
+			Duration:   m.Duration(),
+			BitRate:    parseInt(m.Format.BitRate),
+			Size:       parseInt(m.Format.Size),
+			Tags:       m.Format.Tags,
+		},
+		Video:     []videos.VideoTrack{},
+		Audio:     []videos.AudioTrack{},
+		Subtitles: []videos.SubtitleTrack{},
+	}
+
+	for _, s := range m.Streams {
+		switch s.CodecType {
+		case "video":
+			if s.coverArt() {
+				continue
+			}
+			width, height := s.FrameSize()
+			_, fps := s.FrameRate()
+			info.Video = append(info.Video, videos.VideoTrack{
+				Index:              s.Index,
+				Codec:              s.CodecName,
+				Profile:            s.Profile,
+				Level:              s.Level,
+				Width:              s.Width,
+				Height:             s.Height,
+				DisplayWidth:       width,
+				DisplayHeight:      height,
+				SampleAspectRatio:  s.SampleAspectRatio,
+				DisplayAspectRatio: s.DisplayAspectRatio,
+				FrameRate:          math.Round(fps*1000) / 1000,
+				BitRate:            parseInt(s.BitRate),
+				PixelFormat:        s.PixFmt,
+				BitDepth:           s.bitDepth(),
+				ColorRange:         s.ColorRange,
+				ColorSpace:         s.ColorSpace,
+				ColorTransfer:      s.ColorTransfer,
+				ColorPrimaries:     s.ColorPrimaries,
+				HDR:                s.hdr(),
+				Rotation:           s.Rotation(),
+				Language:           s.language(),
+				Default:            s.Disposition["default"] == 1,
+			})
+		case "audio":
+			info.Audio = append(info.Audio, videos.AudioTrack{
+				Index:         s.Index,
+				Codec:         s.CodecName,
+				Profile:       s.Profile,
+				Channels:      s.Channels,
+				ChannelLayout: s.ChannelLayout,
+				SampleRate:    int(parseInt(s.SampleRate)),
+				BitRate:       parseInt(s.BitRate),
+				Language:      s.language(),
+				Title:         s.Tags["title"],
+				Default:       s.Disposition["default"] == 1,
+			})
+		case "subtitle":
+			info.Subtitles = append(info.Subtitles, videos.SubtitleTrack{
+				Index:    s.Index,
+				Codec:    s.CodecName,
+				Language: s.language(),
+				Title:    s.Tags["title"],
+				Default:  s.Disposition["default"] == 1,
+				Forced:   s.Disposition["forced"] == 1,
+			})
+		}
+	}
+	return info
+}
+
+// coverArt reports whether a video stream is an embedded picture, which
+// ffprobe lists as a single-frame video stream
+func (s *StreamMetadata) coverArt() bool {
+	return s.Disposition["attached_pic"] == 1
+}
+
+// Rotation is the display rotation in degrees, normalized to 0, 90, 180 or 270
+func (s *StreamMetadata) Rotation() int {
+	degrees := 0.0
+	if v, err := strconv.ParseFloat(s.Tags["rotate"], 64); err == nil {
+		degrees = v
+	}
+	for _, d := range s.SideDataList {
+		if d.Rotation != 0 {
+			degrees = d.Rotation
+		}
+	}
+	r := int(math.Round(degrees/90)) * 90 % 360
+	if r < 0 {
+		r += 360
+	}
+	return r
+}
+
+// FrameSize is the displayed size of the video: anamorphic widths are
+// stretched by the sample aspect ratio and rotation is applied like ffmpeg does
+func (s *StreamMetadata) FrameSize() (width, height int) {
+	width, height = s.Width, s.Height
+	if sar := parseRational(strings.Replace(s.SampleAspectRatio, ":", "/", 1)); sar > 0 && sar != 1 {
+		width = int(math.Round(float64(width) * sar))
+	}
+	if r := s.Rotation(); r == 90 || r == 270 {
+		return height, width
+	}
+	return width, height
+}
+
+// FrameRate returns the average frame rate as ffprobe's rational string and
+// as a number, falling back to r_frame_rate when the average is unknown
+func (s *StreamMetadata) FrameRate() (string, float64) {
+	for _, rate := range []string{s.AvgFrameRate, s.RFrameRate} {
+		if fps := parseRational(rate); fps > 0 {
+			return rate, fps
+		}
+	}
+	return "", 0
+}
+
+// bitDepth is the bits per sample, read from the pixel format when the
+// stream does not report it
+func (s *StreamMetadata) bitDepth() int {
+	if bits := parseInt(s.BitsPerRawSample); bits > 0 {
+		return int(bits)
+	}
+	for _, bits := range []int{16, 14, 12, 10, 9} {
+		if strings.Contains(s.PixFmt, fmt.Sprintf("p%d", bits)) {
+			return bits
+		}
+	}
+	if s.PixFmt != "" {
+		return 8
+	}
+	return 0
+}
+
+// hdr names the high dynamic range format of a video stream, "" for SDR
+func (s *StreamMetadata) hdr() string {
+	for _, d := range s.SideDataList {
+		if d.SideDataType == "DOVI configuration record" {
+			return "Dolby Vision"
+		}
+	}
+	switch s.ColorTransfer {
+	case "smpte2084":
+		return "PQ"
+	case "arib-std-b67":
+		return "HLG"
+	}
+	return ""
+}
+
+// language is the stream's language tag, leaving out "und" (undetermined)
+func (s *StreamMetadata) language() string {
+	if lang := s.Tags["language"]; lang != "und" {
+		return lang
+	}
+	return ""
+}
+
+// parseRational parses "30000/1001" or "25", returning 0 if invalid
+func parseRational(value string) float64 {
+	num, den, ok := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// parseInt parses the numbers ffprobe reports as strings, returning 0 for
+// "N/A" and other invalid values
+func parseInt(value string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
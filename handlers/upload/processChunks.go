@@ -1 +1,427 @@
 package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Chunked uploads let a client send a large file as numbered chunks, ask which
+// chunks already arrived after a dropped connection, and resume from there.
+// Session state and chunks live in the object store under uploads/<uploadID>/, so a
+// session survives restarts and can be resumed against any instance.
+
+const (
+	uploadsPrefix    = "uploads"
+	defaultChunkSize = 8 * 1024 * 1024  // 8MB
+	minChunkSize     = 256 * 1024       // 256KB
+	maxChunkSize     = 64 * 1024 * 1024 // 64MB, chunks are buffered in memory
+	chunkChecksumKey = "X-Chunk-Checksum"
+)
+
+// uploadSession is persisted as uploads/<uploadID>/session.json
+type uploadSession struct {
+	UploadID    string    `json:"upload_id"`
+	VideoID     string    `json:"video_id"`
+	FileName    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	ChunkSize   int64     `json:"chunk_size"`
+	TotalChunks int       `json:"total_chunks"`
+	Profile     string    `json:"profile"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type startUploadRequest struct {
+	FileName  string `json:"filename" binding:"required"`
+	Size      int64  `json:"size" binding:"required"`
+	ChunkSize int64  `json:"chunk_size"`
+	Profile   string `json:"profile"`
+}
+
+// StartChunkedUpload creates a new upload session
+func StartChunkedUpload(c *gin.Context) {
+	var req startUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename and size are required"})
+		return
+	}
+
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	}
+	if req.Size > maxFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File size exceeds 2GB limit"})
+		return
+	}
+
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultChunkSize
+	}
+	if req.ChunkSize < minChunkSize || req.ChunkSize > maxChunkSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("chunk_size must be between %d and %d bytes", minChunkSize, maxChunkSize)})
+		return
+	}
+
+	profile, ok := profileFromRequest(c, req.Profile)
+	if !ok {
+		return
+	}
+
+	session := uploadSession{
+		UploadID:    uuid.New().String(),
+		VideoID:     uuid.New().String(),
+		FileName:    filepath.Base(req.FileName),
+		Size:        req.Size,
+		ChunkSize:   req.ChunkSize,
+		TotalChunks: int((req.Size + req.ChunkSize - 1) / req.ChunkSize),
+		Profile:     profile.Name,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	ctx := c.Request.Context()
+	if err := writeSession(ctx, &session); err != nil {
+		fmt.Printf("Failed to save upload session %s: %v\n", session.UploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload session"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// UploadChunk stores a single chunk after verifying its SHA-256 checksum.
+// Re-sending a chunk that already arrived overwrites it.
+func UploadChunk(c *gin.Context) {
+	ctx := c.Request.Context()
+	session, ok := loadSession(c)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= session.TotalChunks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("chunk index must be between 0 and %d", session.TotalChunks-1)})
+		return
+	}
+
+	checksum := strings.ToLower(strings.TrimSpace(c.GetHeader(chunkChecksumKey)))
+	if checksum == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": chunkChecksumKey + " header (hex SHA-256) is required"})
+		return
+	}
+
+	// Every chunk has the session chunk size except possibly the last one
+	expected := session.ChunkSize
+	if index == session.TotalChunks-1 {
+		expected = session.Size - int64(index)*session.ChunkSize
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, expected+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read chunk"})
+		return
+	}
+	if int64(len(data)) != expected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("chunk %d must be exactly %d bytes", index, expected)})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Checksum mismatch, resend the chunk"})
+		return
+	}
+
+	if err := objectstore.Default.Put(ctx, chunkObjectPath(session.UploadID, index), bytes.NewReader(data), "application/octet-stream"); err != nil {
+		fmt.Printf("Failed to store chunk %d of upload %s: %v\n", index, session.UploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"upload_id": session.UploadID, "index": index, "size": expected})
+}
+
+// GetChunkedUploadStatus reports which chunks have arrived so a client can resume
+func GetChunkedUploadStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	session, ok := loadSession(c)
+	if !ok {
+		return
+	}
+
+	received, bytesReceived, err := listChunks(ctx, session.UploadID)
+	if err != nil {
+		fmt.Printf("Failed to list chunks of upload %s: %v\n", session.UploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list chunks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload_id":      session.UploadID,
+		"video_id":       session.VideoID,
+		"size":           session.Size,
+		"chunk_size":     session.ChunkSize,
+		"total_chunks":   session.TotalChunks,
+		"received":       received,
+		"missing":        missingChunks(received, session.TotalChunks),
+		"bytes_received": bytesReceived,
+	})
+}
+
+// CompleteChunkedUpload assembles all chunks into the video object and starts encoding
+func CompleteChunkedUpload(c *gin.Context) {
+	ctx := c.Request.Context()
+	session, ok := loadSession(c)
+	if !ok {
+		return
+	}
+
+	received, bytesReceived, err := listChunks(ctx, session.UploadID)
+	if err != nil {
+		fmt.Printf("Failed to list chunks of upload %s: %v\n", session.UploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list chunks"})
+		return
+	}
+	if missing := missingChunks(received, session.TotalChunks); len(missing) > 0 || bytesReceived != session.Size {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is incomplete", "missing": missing})
+		return
+	}
+
+	parts := make([]string, session.TotalChunks)
+	for i := range parts {
+		parts[i] = chunkObjectPath(session.UploadID, i)
+	}
+
+	newFileName := session.VideoID + filepath.Ext(session.FileName)
+	objectPath := fmt.Sprintf("videos/%s/%s", session.VideoID, newFileName)
+	if err := assembleObject(ctx, parts, objectPath, objectstore.ContentType(newFileName)); err != nil {
+		fmt.Printf("Failed to assemble upload %s: %v\n", session.UploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assemble upload"})
+		return
+	}
+
+	// Check what was uploaded; a rejected upload cannot be completed again
+	contentType, meta, err := checkUpload(ctx, objectPath)
+	if err != nil {
+		var rejected *uploadError
+		if errors.As(err, &rejected) {
+			if err := deletePrefix(ctx, uploadPrefix(session.UploadID)); err != nil {
+				fmt.Printf("Warning: failed to clean up upload %s: %v\n", session.UploadID, err)
+			}
+		}
+		respondUploadError(c, err, "Failed to check uploaded file")
+		return
+	}
+
+	video := &videos.Video{
+		ID:           session.VideoID,
+		FileName:     session.FileName,
+		SourceObject: objectPath,
+		Size:         session.Size,
+		ContentType:  contentType,
+		Profile:      session.Profile,
+	}
+	if err := registerUpload(ctx, video, newFileName, meta); err != nil {
+		fmt.Printf("Failed to queue video %s for encoding: %v\n", session.VideoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue video for encoding"})
+		return
+	}
+
+	// Chunks are no longer needed once the video object exists
+	if err := deletePrefix(ctx, uploadPrefix(session.UploadID)); err != nil {
+		fmt.Printf("Warning: failed to clean up upload %s: %v\n", session.UploadID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "File uploaded successfully",
+		"video_id":  session.VideoID,
+		"video_url": manifestURL(ctx, session.VideoID, session.Profile),
+	})
+}
+
+// registerUpload records a checked upload as a video and queues its encode.
+// A completion that failed halfway is retried by the client, so a video or
+// job left by an earlier attempt is kept rather than created again.
+func registerUpload(ctx context.Context, video *videos.Video, fileName string, meta *VideoMetadata) error {
+	if _, err := videos.Get(ctx, video.ID); err == videos.ErrNotFound {
+		if err := videos.Create(ctx, video); err != nil {
+			return fmt.Errorf("recording video: %w", err)
+		}
+		events.Publish(events.UploadReceived, video.ID, map[string]interface{}{"filename": video.FileName, "size": video.Size})
+	} else if err != nil {
+		return fmt.Errorf("loading video: %w", err)
+	}
+	recordMediaInfo(ctx, video.ID, meta)
+
+	if _, err := jobs.LatestStatus(ctx, video.ID); err == jobs.ErrNotFound {
+		if _, err := jobs.Enqueue(ctx, video.ID, fileName, video.Profile); err != nil {
+			return fmt.Errorf("queueing encoding job: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("loading encoding job: %w", err)
+	}
+	return nil
+}
+
+func uploadPrefix(uploadID string) string {
+	return fmt.Sprintf("%s/%s/", uploadsPrefix, uploadID)
+}
+
+func chunkObjectPath(uploadID string, index int) string {
+	return fmt.Sprintf("%schunks/%06d", uploadPrefix(uploadID), index)
+}
+
+// loadSession reads the session named by the uploadID route parameter,
+// writing an error response and returning false if it cannot be used
+func loadSession(c *gin.Context) (*uploadSession, bool) {
+	uploadID := c.Param("uploadID")
+	if _, err := uuid.Parse(uploadID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return nil, false
+	}
+
+	var session uploadSession
+	err := readJSONObject(c.Request.Context(), uploadPrefix(uploadID)+"session.json", &session)
+	if err == objectstore.ErrNotExist {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload session not found"})
+		return nil, false
+	}
+	if err != nil {
+		fmt.Printf("Failed to read upload session %s: %v\n", uploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload session"})
+		return nil, false
+	}
+
+	return &session, true
+}
+
+func writeSession(ctx context.Context, session *uploadSession) error {
+	return writeJSONObject(ctx, uploadPrefix(session.UploadID)+"session.json", session)
+}
+
+func readJSONObject(ctx context.Context, objectPath string, v interface{}) error {
+	rc, err := objectstore.Default.Get(ctx, objectPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func writeJSONObject(ctx context.Context, objectPath string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return objectstore.Default.Put(ctx, objectPath, bytes.NewReader(data), "application/json")
+}
+
+// listChunks returns the sorted indexes of stored chunks and their total size
+func listChunks(ctx context.Context, uploadID string) ([]int, int64, error) {
+	objects, err := objectstore.Default.List(ctx, uploadPrefix(uploadID)+"chunks/")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	received := []int{}
+	var total int64
+	for _, obj := range objects {
+		index, err := strconv.Atoi(path.Base(obj.Key))
+		if err != nil {
+			continue
+		}
+		received = append(received, index)
+		total += obj.Size
+	}
+
+	sort.Ints(received)
+	return received, total, nil
+}
+
+func missingChunks(received []int, total int) []int {
+	have := make(map[int]bool, len(received))
+	for _, i := range received {
+		have[i] = true
+	}
+
+	missing := []int{}
+	for i := 0; i < total; i++ {
+		if !have[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// assembleObject concatenates the given objects, in order, into dst
+func assembleObject(ctx context.Context, parts []string, dst, contentType string) error {
+	return objectstore.Default.Put(ctx, dst, &partsReader{ctx: ctx, parts: parts}, contentType)
+}
+
+// partsReader reads a list of objects one after another, opening each lazily
+type partsReader struct {
+	ctx   context.Context
+	parts []string
+	cur   io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := objectstore.Default.Get(r.ctx, r.parts[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to read %s: %w", r.parts[0], err)
+			}
+			r.cur = rc
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func deletePrefix(ctx context.Context, prefix string) error {
+	objects, err := objectstore.Default.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := objectstore.Default.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package upload
+
+import (
+	"context"
+	"testing"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/dbtest"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/videos"
+)
+
+// breakJobQueue makes jobs.Enqueue fail until the returned func is called
+func breakJobQueue(t *testing.T) (restore func()) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`CREATE TRIGGER queue_down BEFORE INSERT ON encoding_jobs BEGIN SELECT RAISE(FAIL, 'queue down'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if _, err := handlers.CloudSQLDB.ExecContext(ctx, `DROP TRIGGER queue_down`); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// countJobs is the number of encoding jobs of a video
+func countJobs(t *testing.T, videoID string) int {
+	t.Helper()
+	var n int
+	if err := handlers.CloudSQLDB.QueryRowContext(context.Background(),
+		`SELECT COUNT(*) FROM encoding_jobs WHERE video_id = ?`, videoID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRegisterUploadRetry(t *testing.T) {
+	dbtest.Open(t)
+	ctx := context.Background()
+	video := &videos.Video{ID: "v1", FileName: "a.mp4", SourceObject: "videos/v1/v1.mp4", Size: 10, ContentType: "video/mp4"}
+
+	restore := breakJobQueue(t)
+	if err := registerUpload(ctx, video, "v1.mp4", nil); err == nil {
+		t.Fatal("registerUpload succeeded without a job queue")
+	}
+	restore()
+	if _, err := videos.Get(ctx, "v1"); err != nil {
+		t.Fatalf("video of the failed attempt: %v", err)
+	}
+
+	// The retry queues the job the first attempt could not
+	if err := registerUpload(ctx, video, "v1.mp4", nil); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	status, err := jobs.LatestStatus(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != jobs.StateQueued {
+		t.Errorf("job state %s, want %s", status.State, jobs.StateQueued)
+	}
+
+	// Completing again queues nothing more
+	if err := registerUpload(ctx, video, "v1.mp4", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countJobs(t, "v1"); n != 1 {
+		t.Errorf("%d jobs after completing twice, want 1", n)
+	}
+}
@@ -0,0 +1,216 @@
+package upload
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Profile is a named set of encoding settings: the ladder, codecs,
+// rate control, keyframe interval, segment length and packaging.
+// Sources keep their own frame rate. FrameRate is used when the source's is
+// unknown and is the rate gop is given at; MaxFrameRate, if set, caps it.
+type Profile struct {
+	Name             string `json:"name"`
+	Packaging        string `json:"packaging"`
+	VideoCodec       string `json:"video_codec"`
+	H264Profile      string `json:"h264_profile"`
+	Preset           string `json:"preset"`
+	CRF              int    `json:"crf"`
+	GOP              int    `json:"gop"`
+	FrameRate        int    `json:"frame_rate"`
+	MaxFrameRate     int    `json:"max_frame_rate"`
+	SegmentSeconds   int    `json:"segment_seconds"`
+	AudioCodec       string `json:"audio_codec"`
+	AudioBitrateKbps int    `json:"audio_bitrate_kbps"`
+	AudioSampleRate  int    `json:"audio_sample_rate"`
+	// SilentAudio adds a silent AAC track to sources without audio, so
+	// every output has an audio track; otherwise their output is video-only
+	SilentAudio bool `json:"silent_audio"`
+	// TrickPlay adds an HLS I-frame playlist and a DASH trick mode
+	// adaptation set for fast scrubbing
+	TrickPlay bool   `json:"trick_play"`
+	Ladder    []Rung `json:"ladder"`
+
+	// Set by forSource when the source frame rate is kept
+	sourceRate string
+	sourceFPS  float64
+	// Set by forSource for a source without audio
+	audio string
+	// Set by forSource to the -map specifier of the probed video stream
+	video string
+}
+
+// Rung is one quality of a profile's ladder, shared by HLS and DASH
+type Rung struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// BitrateKbps caps the video bitrate
+	BitrateKbps int `json:"bitrate_kbps"`
+}
+
+type profilesFile struct {
+	Default  string              `json:"default"`
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// defaultProfiles is used unless ENCODING_PROFILES_FILE names another file
+//
+//go:embed profiles.json
+var defaultProfiles []byte
+
+var (
+	profiles       map[string]*Profile
+	defaultProfile string
+)
+
+// ErrUnknownProfile is returned for a profile name missing from the config
+var ErrUnknownProfile = errors.New("unknown encoding profile")
+
+// h264Profiles maps the supported H.264 profiles to the profile_idc and
+// constraint flags bytes of their RFC 6381 codecs string, as x264 writes them
+var h264Profiles = map[string]string{
+	"baseline": "42c0",
+	"main":     "4d40",
+	"high":     "6400",
+}
+
+var x264Presets = map[string]bool{
+	"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true,
+	"medium": true, "slow": true, "slower": true, "veryslow": true,
+}
+
+// InitProfiles loads the encoding profiles from ENCODING_PROFILES_FILE, or
+// the built-in mobile, standard and premium profiles when it is unset.
+// ENCODING_PROFILE overrides the profile used when an upload names none.
+func InitProfiles() error {
+	data := defaultProfiles
+	if path := os.Getenv("ENCODING_PROFILES_FILE"); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return fmt.Errorf("failed to read encoding profiles: %w", err)
+		}
+	}
+
+	var file profilesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse encoding profiles: %w", err)
+	}
+	if len(file.Profiles) == 0 {
+		return errors.New("no encoding profiles configured")
+	}
+	for name, p := range file.Profiles {
+		p.Name = name
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("encoding profile %s: %w", name, err)
+		}
+	}
+
+	if name := os.Getenv("ENCODING_PROFILE"); name != "" {
+		file.Default = name
+	}
+	if _, ok := file.Profiles[file.Default]; !ok {
+		return fmt.Errorf("default encoding profile %q is not configured", file.Default)
+	}
+
+	profiles, defaultProfile = file.Profiles, file.Default
+	return nil
+}
+
+// lookupProfile returns the named profile, or the default one for ""
+func lookupProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = defaultProfile
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	return p, nil
+}
+
+func (p *Profile) validate() error {
+	if p.Packaging != PackagingSeparate && p.Packaging != PackagingCMAF {
+		return fmt.Errorf("packaging must be %s or %s", PackagingSeparate, PackagingCMAF)
+	}
+	if p.VideoCodec != "h264" {
+		return errors.New("video_codec must be h264")
+	}
+	if _, ok := h264Profiles[p.H264Profile]; !ok {
+		return errors.New("h264_profile must be baseline, main or high")
+	}
+	if !x264Presets[p.Preset] {
+		return fmt.Errorf("unknown preset %q", p.Preset)
+	}
+	if p.CRF < 0 || p.CRF > 51 {
+		return errors.New("crf must be between 0 and 51")
+	}
+	if p.GOP <= 0 || p.FrameRate <= 0 || p.SegmentSeconds <= 0 {
+		return errors.New("gop, frame_rate and segment_seconds must be positive")
+	}
+	// With scene cut detection off keyframes come every gop frames, and a
+	// segment can only be cut at one
+	if p.SegmentSeconds*p.FrameRate%p.GOP != 0 {
+		return fmt.Errorf("gop must divide the %d frames of a segment", p.SegmentSeconds*p.FrameRate)
+	}
+	if p.MaxFrameRate < 0 {
+		return errors.New("max_frame_rate must not be negative")
+	}
+	if p.AudioCodec != "aac" {
+		return errors.New("audio_codec must be aac")
+	}
+	if p.AudioBitrateKbps <= 0 || p.AudioSampleRate <= 0 {
+		return errors.New("audio_bitrate_kbps and audio_sample_rate must be positive")
+	}
+	if len(p.Ladder) == 0 {
+		return errors.New("ladder is empty")
+	}
+
+	names := map[string]bool{}
+	for _, r := range p.Ladder {
+		if r.Name == "" || names[r.Name] {
+			return fmt.Errorf("rung names must be unique and not empty (%q)", r.Name)
+		}
+		names[r.Name] = true
+		if r.Width <= 0 || r.Height <= 0 || r.Width%2 != 0 || r.Height%2 != 0 {
+			return fmt.Errorf("rung %s: width and height must be positive and even", r.Name)
+		}
+		if r.BitrateKbps <= 0 {
+			return fmt.Errorf("rung %s: bitrate_kbps must be positive", r.Name)
+		}
+	}
+
+	// Players take the first variant as the starting point, so list rungs
+	// from the lowest quality up
+	sort.SliceStable(p.Ladder, func(i, j int) bool { return p.Ladder[i].Height < p.Ladder[j].Height })
+	return nil
+}
+
+// ListProfiles returns the configured encoding profiles and the default one
+func ListProfiles(c *gin.Context) {
+	list := make([]*Profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"default": defaultProfile, "profiles": list})
+}
+
+// profileFromRequest resolves the profile an upload asked for, responding
+// with 400 for an unknown one
+func profileFromRequest(c *gin.Context, name string) (*Profile, bool) {
+	p, err := lookupProfile(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown encoding profile %q", name)})
+		return nil, false
+	}
+	return p, true
+}
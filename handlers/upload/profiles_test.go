@@ -0,0 +1,40 @@
+package upload
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateKeyframeAlignment(t *testing.T) {
+	tests := []struct {
+		gop, frameRate, segmentSeconds int
+		ok                             bool
+	}{
+		{gop: 60, frameRate: 30, segmentSeconds: 6, ok: true},
+		{gop: 90, frameRate: 30, segmentSeconds: 6, ok: true},
+		{gop: 50, frameRate: 25, segmentSeconds: 4, ok: true},
+		// 1.6 s keyframes: a 6 s segment could only be cut at 6.4 s
+		{gop: 48, frameRate: 30, segmentSeconds: 6, ok: false},
+		{gop: 48, frameRate: 30, segmentSeconds: 10, ok: false},
+	}
+	for _, tt := range tests {
+		p := &Profile{
+			Packaging: PackagingCMAF, VideoCodec: "h264", H264Profile: "main", Preset: "fast", CRF: 23,
+			GOP: tt.gop, FrameRate: tt.frameRate, SegmentSeconds: tt.segmentSeconds,
+			AudioCodec: "aac", AudioBitrateKbps: 128, AudioSampleRate: 48000,
+			Ladder: []Rung{{Name: "360p", Width: 640, Height: 360, BitrateKbps: 800}},
+		}
+		err := p.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("gop %d at %d fps, %d s segments: %v", tt.gop, tt.frameRate, tt.segmentSeconds, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "gop") {
+			t.Errorf("gop %d at %d fps, %d s segments: unexpected error %v", tt.gop, tt.frameRate, tt.segmentSeconds, err)
+		}
+	}
+
+	// The shipped profiles are aligned
+	if err := InitProfiles(); err != nil {
+		t.Fatal(err)
+	}
+}
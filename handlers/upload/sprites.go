@@ -0,0 +1,75 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Seek-preview sprites stored under videos/<id>/sprites: sheets of
+// spriteColumns x spriteRows tiles, sprite_001.jpg onwards, and
+// thumbnails.vtt with a cue per tile pointing into a sheet with #xywh
+const (
+	spritesFormat   = "sprites"
+	spritesVTTName  = "thumbnails.vtt"
+	spriteTileWidth = 160
+	spriteColumns   = 10
+	spriteRows      = 10
+	// A tile per second, spaced out for long videos to keep to about
+	// spriteMaxTiles tiles, but never more than spriteMaxInterval apart
+	spriteMaxTiles    = 300
+	spriteMaxInterval = 10.0
+)
+
+// extractSprites writes the sprite sheets and their WebVTT track for the
+// video stream videoMap of a source of duration seconds displayed at
+// width x height
+func extractSprites(ctx context.Context, inputPath, videoMap, outputDir string, duration float64, width, height int) error {
+	if duration <= 0 || width <= 0 || height <= 0 {
+		return fmt.Errorf("source duration and size are unknown")
+	}
+
+	interval := math.Min(math.Max(1, math.Ceil(duration/spriteMaxTiles)), spriteMaxInterval)
+	tileHeight := evenScale(spriteTileWidth, height, width)
+
+	args := []string{
+		"-y", "-i", inputPath, "-map", videoMap,
+		"-vf", fmt.Sprintf("fps=1/%g,%s,scale=%d:%d,tile=%dx%d",
+			interval, squarePixels, spriteTileWidth, tileHeight, spriteColumns, spriteRows),
+		"-q:v", "4", filepath.Join(outputDir, "sprite_%03d.jpg"),
+	}
+	if err := runFFmpeg(ctx, args, duration, nil); err != nil {
+		return err
+	}
+
+	vtt := spritesVTT(duration, interval, spriteTileWidth, tileHeight)
+	return os.WriteFile(filepath.Join(outputDir, spritesVTTName), []byte(vtt), 0o644)
+}
+
+// spritesVTT lists a cue per interval, each showing the tile the fps filter
+// took at its start
+func spritesVTT(duration, interval float64, tileWidth, tileHeight int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	perSheet := spriteColumns * spriteRows
+	for i := 0; float64(i)*interval < duration; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, duration)
+		sheet, tile := i/perSheet+1, i%perSheet
+		x, y := (tile%spriteColumns)*tileWidth, (tile/spriteColumns)*tileHeight
+
+		fmt.Fprintf(&b, "\n%s --> %s\nsprite_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), sheet, x, y, tileWidth, tileHeight)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp, HH:MM:SS.mmm
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
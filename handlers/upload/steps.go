@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"context"
+
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+)
+
+// Pipeline steps reported through GET /videos/:videoID/status
+const (
+	StepDownload   = "download"
+	StepHLSEncode  = "hls_encode"
+	StepDASHEncode = "dash_encode"
+	StepCMAFEncode = "cmaf_encode"
+	StepThumbnails = "thumbnails"
+	StepUpload     = "upload"
+)
+
+// stepRanges is the slice of overall progress, in percent, each step covers
+var stepRanges = map[string][2]float64{
+	StepDownload:   {0, 10},
+	StepHLSEncode:  {10, 40},
+	StepDASHEncode: {40, 80},
+	StepCMAFEncode: {10, 80},
+	StepThumbnails: {80, 85},
+	StepUpload:     {85, 100},
+}
+
+// stepFormats names the output format produced by each encode step
+var stepFormats = map[string]string{
+	StepHLSEncode:  "HLS",
+	StepDASHEncode: "DASH",
+	StepCMAFEncode: "CMAF",
+}
+
+// reportStep records that job is fraction (0-1) of the way through step
+func reportStep(ctx context.Context, job *jobs.Job, step string, fraction float64) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	r := stepRanges[step]
+	job.SetProgress(ctx, step, r[0]+(r[1]-r[0])*fraction)
+}
+
+// encodeProgress publishes ffmpeg progress for an encode step, both as the
+// job's live progress and as its overall percentage
+func encodeProgress(ctx context.Context, job *jobs.Job, step string) func(EncodeProgress) {
+	return func(p EncodeProgress) {
+		job.SetLive(jobs.LiveProgress{
+			Step:       step,
+			Percent:    p.Percent,
+			Frame:      p.Frame,
+			Speed:      p.Speed,
+			ETASeconds: p.ETA.Seconds(),
+		})
+		reportStep(ctx, job, step, p.Percent/100)
+
+		events.Publish(events.EncodingProgress, job.VideoID, map[string]interface{}{
+			"format":      stepFormats[step],
+			"percent":     p.Percent,
+			"frame":       p.Frame,
+			"speed":       p.Speed,
+			"eta_seconds": p.ETA.Seconds(),
+		})
+	}
+}
+
+// progressReader reports download progress as the source is read
+type progressReader struct {
+	ctx   context.Context
+	job   *jobs.Job
+	r     countingReader
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if p.total > 0 {
+		reportStep(p.ctx, p.job, StepDownload, float64(p.r.n)/float64(p.total))
+	}
+	return n, err
+}
@@ -0,0 +1,140 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Stills stored under videos/<id>/thumbnails: poster.jpg, and for every
+// width in thumbnailWidths a folder of thumbnailCount frames 001.jpg onwards
+// taken at regular intervals
+const (
+	thumbnailsFormat = "thumbnails"
+	posterName       = "poster.jpg"
+	posterMaxWidth   = 1280
+	thumbnailCount   = 10
+)
+
+var thumbnailWidths = []int{160, 320, 640}
+
+// squarePixels stretches anamorphic video to its display aspect ratio, so
+// scaling to a width with -2 keeps the picture's proportions
+const squarePixels = "scale='trunc(iw*sar/2)*2':ih,setsar=1"
+
+// stillsPass extracts images from the source into their own folder, stored
+// under videos/<id>/<format>
+type stillsPass struct {
+	format  string
+	dir     string
+	extract func(dir string) error
+}
+
+// stillsPasses returns the image outputs of a source: the poster and
+// thumbnails, and seek-preview sprites when the probe found its size
+func stillsPasses(ctx context.Context, inputPath, videoID string, duration float64, meta *VideoMetadata) []stillsPass {
+	videoMap := meta.VideoMap()
+	passes := []stillsPass{{
+		format: thumbnailsFormat,
+		dir:    filepath.ToSlash(filepath.Join(localStorage, videoID+"_thumbnails")),
+		extract: func(dir string) error {
+			return extractThumbnails(ctx, inputPath, videoMap, dir, duration)
+		},
+	}}
+
+	if meta == nil || meta.VideoStream() == nil {
+		return passes
+	}
+	width, height := meta.VideoStream().FrameSize()
+	return append(passes, stillsPass{
+		format: spritesFormat,
+		dir:    filepath.ToSlash(filepath.Join(localStorage, videoID+"_sprites")),
+		extract: func(dir string) error {
+			return extractSprites(ctx, inputPath, videoMap, dir, duration, width, height)
+		},
+	})
+}
+
+// extractThumbnails writes the poster frame and thumbnails of the video
+// stream videoMap of a source of duration seconds into outputDir
+func extractThumbnails(ctx context.Context, inputPath, videoMap, outputDir string, duration float64) error {
+	if err := extractPoster(ctx, inputPath, videoMap, filepath.Join(outputDir, posterName), duration); err != nil {
+		return fmt.Errorf("poster: %w", err)
+	}
+	if duration <= 0 {
+		// Without a duration there are no intervals to take thumbnails at
+		return nil
+	}
+
+	for _, width := range thumbnailWidths {
+		if err := os.MkdirAll(filepath.Join(outputDir, strconv.Itoa(width)), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// Seeking to each offset is much faster than decoding the whole source
+	interval := duration / thumbnailCount
+	for i := 0; i < thumbnailCount; i++ {
+		offset := (float64(i) + 0.5) * interval
+		if err := runFFmpeg(ctx, thumbnailArgs(inputPath, videoMap, outputDir, offset, i+1), 0, nil); err != nil {
+			return fmt.Errorf("thumbnail at %.1fs: %w", offset, err)
+		}
+	}
+	return nil
+}
+
+// thumbnailArgs grab the frame at offset once and scale it to every width
+func thumbnailArgs(inputPath, videoMap, outputDir string, offset float64, n int) []string {
+	graph := fmt.Sprintf("[%s]%s,split=%d", videoMap, squarePixels, len(thumbnailWidths))
+	for i := range thumbnailWidths {
+		graph += fmt.Sprintf("[s%d]", i)
+	}
+	for i, width := range thumbnailWidths {
+		graph += fmt.Sprintf(";[s%d]scale='min(%d,iw)':-2[t%d]", i, width, i)
+	}
+
+	args := []string{"-y", "-ss", formatSeconds(offset), "-i", inputPath, "-filter_complex", graph}
+	for i, width := range thumbnailWidths {
+		args = append(args,
+			"-map", fmt.Sprintf("[t%d]", i), "-frames:v", "1", "-update", "1", "-q:v", "3",
+			filepath.Join(outputDir, strconv.Itoa(width), fmt.Sprintf("%03d.jpg", n)),
+		)
+	}
+	return args
+}
+
+// extractPoster picks the poster frame a tenth of the way in, at most a
+// minute, past intros and fades. Among the next frames that are not mostly
+// black the thumbnail filter takes the most representative one. If every
+// one of them is black, or the filters fail, the frame at the offset is
+// used as it is.
+func extractPoster(ctx context.Context, inputPath, videoMap, posterPath string, duration float64) error {
+	offset := math.Min(duration/10, 60)
+	scale := fmt.Sprintf("%s,scale='min(%d,iw)':-2", squarePixels, posterMaxWidth)
+
+	smart := []string{
+		"-y", "-ss", formatSeconds(offset), "-i", inputPath, "-map", videoMap,
+		"-vf", "blackframe=amount=0:threshold=32," +
+			"metadata=mode=select:key=lavfi.blackframe.pblack:value=90:function=less," +
+			"thumbnail=n=50," + scale,
+		"-frames:v", "1", "-update", "1", "-q:v", "2", posterPath,
+	}
+	if err := runFFmpeg(ctx, smart, 0, nil); err == nil {
+		if _, err := os.Stat(posterPath); err == nil {
+			return nil
+		}
+	}
+
+	plain := []string{
+		"-y", "-ss", formatSeconds(offset), "-i", inputPath, "-map", videoMap,
+		"-vf", scale, "-frames:v", "1", "-update", "1", "-q:v", "2", posterPath,
+	}
+	return runFFmpeg(ctx, plain, 0, nil)
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
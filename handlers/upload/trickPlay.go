@@ -0,0 +1,157 @@
+package upload
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Trick play outputs of a profile with trick_play. Both are one intra-coded
+// frame per second at the lowest rung's size, encoded as extra outputs of the
+// HLS, DASH or CMAF ffmpeg run.
+const (
+	iframesPlaylist = "iframes.m3u8"
+	trickManifest   = "trick.mpd"
+	trickModeScheme = "http://dashif.org/guidelines/trickmode"
+)
+
+var (
+	adaptationSetID  = regexp.MustCompile(`<AdaptationSet id="(\d+)"`)
+	representationID = regexp.MustCompile(`<Representation id="[^"]*"`)
+)
+
+// trickRung is the size and level of trick play frames
+func (p *Profile) trickRung() Rung {
+	return p.Ladder[0]
+}
+
+// trickArgs are the options of a trick play output: video only, every frame
+// a keyframe, one frame per second
+func (p *Profile) trickArgs() []string {
+	r := p.trickRung()
+	return []string{
+		"-map", p.videoMap(), "-an",
+		"-vf", fmt.Sprintf("scale=%d:%d,setsar=1", r.Width, r.Height), "-r", "1",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-preset", p.Preset, "-crf", strconv.Itoa(p.CRF),
+		"-profile:v", p.H264Profile, "-level:v", strconv.Itoa(r.level(1)), "-g", "1",
+	}
+}
+
+// iframeArgs add the output of iframes.m3u8. One-second segments of a 1 fps
+// stream hold a single I-frame each, stored as byte ranges of one file, so
+// finishIFrames only has to mark the playlist I-frame only.
+func (p *Profile) iframeArgs(outputDir string, fmp4 bool) []string {
+	args := append(p.trickArgs(),
+		"-f", "hls", "-hls_time", "1", "-hls_playlist_type", "vod", "-hls_flags", "single_file",
+	)
+	if fmp4 {
+		args = append(args, "-hls_segment_type", "fmp4")
+	}
+	return append(args, filepath.Join(outputDir, iframesPlaylist))
+}
+
+// trickDASHArgs add the output of trick.mpd, whose adaptation set
+// spliceTrickMode moves into manifest.mpd
+func (p *Profile) trickDASHArgs(outputDir string) []string {
+	return append(p.trickArgs(),
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(p.SegmentSeconds),
+		"-use_timeline", "1",
+		"-use_template", "1",
+		"-init_seg_name", "trick-init.m4s",
+		"-media_seg_name", "trick-$Number$.m4s",
+		filepath.ToSlash(filepath.Join(outputDir, trickManifest)),
+	)
+}
+
+// finishIFrames marks iframes.m3u8 as an I-frame playlist
+func finishIFrames(outputDir string) error {
+	path := filepath.Join(outputDir, iframesPlaylist)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines)+1)
+	for _, line := range lines {
+		// Byte ranges need version 4
+		if strings.HasPrefix(line, "#EXT-X-VERSION:") {
+			if v, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-VERSION:")); v < 4 {
+				line = "#EXT-X-VERSION:4"
+			}
+		}
+		out = append(out, line)
+		if line == "#EXTM3U" {
+			out = append(out, "#EXT-X-I-FRAMES-ONLY")
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(out, "\n")), 0o644)
+}
+
+// spliceTrickMode moves the adaptation set of trick.mpd into manifest.mpd,
+// marked with the DASH-IF trick mode property as belonging to the main video
+// adaptation set
+func (p *Profile) spliceTrickMode(outputDir string) error {
+	mainPath := filepath.Join(outputDir, "manifest.mpd")
+	trickPath := filepath.Join(outputDir, trickManifest)
+	manifest, err := os.ReadFile(mainPath)
+	if err != nil {
+		return err
+	}
+	trick, err := os.ReadFile(trickPath)
+	if err != nil {
+		return err
+	}
+
+	start := strings.Index(string(trick), "<AdaptationSet")
+	end := strings.LastIndex(string(trick), "</AdaptationSet>")
+	if start < 0 || end < 0 {
+		return fmt.Errorf("no adaptation set in %s", trickManifest)
+	}
+	set := string(trick)[start : end+len("</AdaptationSet>")]
+
+	// Ids must be unique within the period; the main video set is the first
+	nextID := 0
+	for _, m := range adaptationSetID.FindAllStringSubmatch(string(manifest), -1) {
+		id, _ := strconv.Atoi(m[1])
+		nextID = max(nextID, id+1)
+	}
+	videoID := adaptationSetID.FindStringSubmatch(string(manifest))
+	if videoID == nil {
+		return fmt.Errorf("no adaptation set in manifest.mpd")
+	}
+
+	set = adaptationSetID.ReplaceAllString(set, fmt.Sprintf(`<AdaptationSet id="%d"`, nextID))
+	openEnd := strings.Index(set, ">") + 1
+	set = set[:openEnd] + fmt.Sprintf("\n\t\t\t<EssentialProperty schemeIdUri=\"%s\" value=\"%s\"/>", trickModeScheme, videoID[1]) + set[openEnd:]
+	set = representationID.ReplaceAllString(set,
+		fmt.Sprintf(`<Representation id="trick" codingDependency="false" maxPlayoutRate="%d"`, int(math.Round(p.fps()))))
+
+	periodEnd := strings.LastIndex(string(manifest), "</Period>")
+	if periodEnd < 0 {
+		return fmt.Errorf("no period in manifest.mpd")
+	}
+	spliced := string(manifest)[:periodEnd] + "\t" + set + "\n\t" + string(manifest)[periodEnd:]
+	if err := os.WriteFile(mainPath, []byte(spliced), 0o644); err != nil {
+		return err
+	}
+	return os.Remove(trickPath)
+}
+
+// iframeStreamInf is the master playlist entry of iframes.m3u8, measured
+// like the variants
+func (p *Profile) iframeStreamInf(dir string) (string, error) {
+	peak, average, err := variantBitrates(dir, iframesPlaylist)
+	if err != nil {
+		return "", fmt.Errorf("measuring %s: %w", iframesPlaylist, err)
+	}
+	r := p.trickRung()
+	codecs := fmt.Sprintf("avc1.%s%02x", h264Profiles[p.H264Profile], r.level(1))
+	return fmt.Sprintf("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",URI=\"%s\"\n",
+		peak, average, r.Width, r.Height, codecs, iframesPlaylist), nil
+}
@@ -0,0 +1,453 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tus 1.0 server (https://tus.io/protocols/resumable-upload) with the
+// creation, termination and checksum extensions. Each PATCH body is stored as
+// its own part object under uploads/<uploadID>/parts/, named by the offset it
+// starts at. Once the last byte arrives the parts are assembled into
+// videos/<videoID>/<videoID><ext> and encoding starts, like UploadVideo.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+	tusAlgorithms = "md5,sha1,sha256"
+
+	// Status code defined by the checksum extension
+	statusChecksumMismatch = 460
+)
+
+// tusUpload is persisted as uploads/<uploadID>/tus.json
+type tusUpload struct {
+	ID        string            `json:"id"`
+	VideoID   string            `json:"video_id"`
+	FileName  string            `json:"filename"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  string            `json:"metadata"`
+	MetaPairs map[string]string `json:"meta_pairs"`
+	Profile   string            `json:"profile"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// TusOptions advertises the server's tus capabilities
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxFileSize, 10))
+	c.Header("Tus-Checksum-Algorithm", tusAlgorithms)
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate implements the creation extension
+func TusCreate(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if length > maxFileSize {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata := c.GetHeader("Upload-Metadata")
+	pairs, err := parseTusMetadata(metadata)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	fileName := pairs["filename"]
+	if fileName == "" {
+		fileName = pairs["name"]
+	}
+
+	profile, ok := profileFromRequest(c, pairs["profile"])
+	if !ok {
+		return
+	}
+
+	upload := tusUpload{
+		ID:        uuid.New().String(),
+		VideoID:   uuid.New().String(),
+		FileName:  filepath.Base(fileName),
+		Length:    length,
+		Metadata:  metadata,
+		MetaPairs: pairs,
+		Profile:   profile.Name,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	ctx := c.Request.Context()
+	if err := writeJSONObject(ctx, tusUploadPath(upload.ID), &upload); err != nil {
+		fmt.Printf("Failed to save tus upload %s: %v\n", upload.ID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Location", path.Join(c.FullPath(), upload.ID))
+	c.Header("X-Video-ID", upload.VideoID)
+	c.Status(http.StatusCreated)
+}
+
+// TusHead reports the current offset of an upload
+func TusHead(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	upload, ok := loadTusUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Header("X-Video-ID", upload.VideoID)
+	c.Status(http.StatusOK)
+}
+
+// TusPatch appends the request body to an upload at Upload-Offset
+func TusPatch(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var expectedSum []byte
+	var hasher hash.Hash
+	if header := c.GetHeader("Upload-Checksum"); header != "" {
+		hasher, expectedSum, err = parseUploadChecksum(header)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// What arrived before the client went away is still stored
+	ctx := context.WithoutCancel(c.Request.Context())
+	upload, ok := loadTusUpload(c)
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	if upload.Offset == upload.Length {
+		// Nothing is left to send, so the client is retrying a completion that failed
+		respondTusPatch(ctx, c, upload)
+		return
+	}
+
+	// Never accept more than the declared length. A body cut short is stored
+	// up to where it broke off, so the client resumes from there.
+	received := &partialReader{r: io.LimitReader(c.Request.Body, upload.Length-upload.Offset)}
+	body := io.Reader(received)
+	if hasher != nil {
+		body = io.TeeReader(body, hasher)
+	}
+	counter := &countingReader{r: body}
+
+	partPath := tusPartPath(upload.ID, offset)
+	if err := objectstore.Default.Put(ctx, partPath, counter, "application/octet-stream"); err != nil {
+		fmt.Printf("Failed to store tus part of upload %s at offset %d: %v\n", upload.ID, offset, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if received.err != nil {
+		fmt.Printf("Reading tus PATCH of upload %s failed after %d bytes: %v\n", upload.ID, counter.n, received.err)
+		// The checksum is of the whole body, so a partial one cannot be verified
+		if hasher != nil || counter.n == 0 {
+			if err := objectstore.Default.Delete(ctx, partPath); err != nil {
+				fmt.Printf("Warning: failed to delete partial tus part %s: %v\n", partPath, err)
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if hasher != nil && !bytes.Equal(hasher.Sum(nil), expectedSum) {
+		if err := objectstore.Default.Delete(ctx, partPath); err != nil {
+			fmt.Printf("Warning: failed to delete rejected tus part %s: %v\n", partPath, err)
+		}
+		c.AbortWithStatus(statusChecksumMismatch)
+		return
+	}
+
+	upload.Offset += counter.n
+	if err := writeJSONObject(ctx, tusUploadPath(upload.ID), upload); err != nil {
+		fmt.Printf("Failed to save tus upload %s: %v\n", upload.ID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if received.err != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	respondTusPatch(ctx, c, upload)
+}
+
+// respondTusPatch reports the offset a PATCH left, finishing the upload when
+// it is complete
+func respondTusPatch(ctx context.Context, c *gin.Context, upload *tusUpload) {
+	if upload.Offset == upload.Length {
+		if err := finishTusUpload(ctx, upload); err != nil {
+			respondUploadError(c, err, "Failed to finish upload")
+			c.Abort()
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// TusDelete implements the termination extension
+func TusDelete(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	upload, ok := loadTusUpload(c)
+	if !ok {
+		return
+	}
+
+	if err := deletePrefix(ctx, uploadPrefix(upload.ID)); err != nil {
+		fmt.Printf("Failed to delete tus upload %s: %v\n", upload.ID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// finishTusUpload assembles the parts into the video object and starts
+// encoding. It can be run again after failing.
+func finishTusUpload(ctx context.Context, upload *tusUpload) error {
+	parts, err := listTusParts(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+
+	newFileName := upload.VideoID + filepath.Ext(upload.FileName)
+	objectPath := fmt.Sprintf("videos/%s/%s", upload.VideoID, newFileName)
+	if err := assembleObject(ctx, parts, objectPath, objectstore.ContentType(newFileName)); err != nil {
+		return err
+	}
+
+	// A rejected upload is complete, so nothing of it is kept
+	contentType, meta, err := checkUpload(ctx, objectPath)
+	if err != nil {
+		var rejected *uploadError
+		if errors.As(err, &rejected) {
+			if err := deletePrefix(ctx, uploadPrefix(upload.ID)); err != nil {
+				fmt.Printf("Warning: failed to clean up tus upload %s: %v\n", upload.ID, err)
+			}
+		}
+		return err
+	}
+
+	// Queue the video for encoding. Until the upload is cleaned up below, a
+	// PATCH at its full offset runs this again.
+	video := &videos.Video{
+		ID:           upload.VideoID,
+		FileName:     upload.FileName,
+		SourceObject: objectPath,
+		Size:         upload.Length,
+		ContentType:  contentType,
+		Profile:      upload.Profile,
+	}
+	if err := registerUpload(ctx, video, newFileName, meta); err != nil {
+		return err
+	}
+
+	if err := deletePrefix(ctx, uploadPrefix(upload.ID)); err != nil {
+		fmt.Printf("Warning: failed to clean up tus upload %s: %v\n", upload.ID, err)
+	}
+	return nil
+}
+
+// checkTusResumable rejects requests for a protocol version we do not speak
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func tusPartPath(uploadID string, offset int64) string {
+	return fmt.Sprintf("%sparts/%020d", uploadPrefix(uploadID), offset)
+}
+
+func tusUploadPath(uploadID string) string {
+	return uploadPrefix(uploadID) + "tus.json"
+}
+
+// loadTusUpload reads the upload named by the uploadID route parameter,
+// writing an error status and returning false if it cannot be used
+func loadTusUpload(c *gin.Context) (*tusUpload, bool) {
+	uploadID := c.Param("uploadID")
+	if _, err := uuid.Parse(uploadID); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+
+	var upload tusUpload
+	err := readJSONObject(c.Request.Context(), tusUploadPath(uploadID), &upload)
+	if err == objectstore.ErrNotExist {
+		c.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Printf("Failed to read tus upload %s: %v\n", uploadID, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return &upload, true
+}
+
+// listTusParts returns the part objects of an upload ordered by offset
+func listTusParts(ctx context.Context, uploadID string) ([]string, error) {
+	objects, err := objectstore.Default.List(ctx, uploadPrefix(uploadID)+"parts/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero padded offsets make key order the byte order
+	parts := make([]string, len(objects))
+	for i, obj := range objects {
+		parts[i] = obj.Key
+	}
+	return parts, nil
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2"
+func parseTusMetadata(header string) (map[string]string, error) {
+	pairs := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return pairs, nil
+	}
+
+	for _, item := range strings.Split(header, ",") {
+		fields := strings.Fields(item)
+		switch len(fields) {
+		case 1:
+			pairs[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q: %w", fields[0], err)
+			}
+			pairs[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", item)
+		}
+	}
+	return pairs, nil
+}
+
+// parseUploadChecksum decodes an "Upload-Checksum: <algorithm> <base64 digest>" header
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum header")
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid checksum encoding: %w", err)
+	}
+
+	switch fields[0] {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", fields[0])
+	}
+}
+
+// partialReader ends at the first read error of r, keeping it in err, so
+// the bytes read before it can still be stored
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
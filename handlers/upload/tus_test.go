@@ -0,0 +1,189 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"packetized-media-streaming/handlers/dbtest"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/objectstoretest"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gin-gonic/gin"
+)
+
+// brokenBody yields data and then fails, like a connection dropped mid-request
+func brokenBody(data string) io.Reader {
+	return io.MultiReader(strings.NewReader(data), &failingReader{})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func tusRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	objectstoretest.Local(t)
+	if err := InitProfiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/files", TusCreate)
+	r.HEAD("/files/:uploadID", TusHead)
+	r.PATCH("/files/:uploadID", TusPatch)
+	return r
+}
+
+func tusRequest(r *gin.Engine, method, target string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createTusUpload(t *testing.T, r *gin.Engine, length string) string {
+	t.Helper()
+	w := tusRequest(r, http.MethodPost, "/files", nil, map[string]string{"Upload-Length": length})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	return "/files/" + path.Base(w.Header().Get("Location"))
+}
+
+func TestTusPatchKeepsReceivedBytes(t *testing.T) {
+	r := tusRouter(t)
+	location := createTusUpload(t, r, "10")
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+
+	w := tusRequest(r, http.MethodPatch, location, brokenBody("hello"), patch)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("interrupted PATCH: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "5" {
+		t.Errorf("interrupted PATCH: Upload-Offset %q, want 5", got)
+	}
+
+	w = tusRequest(r, http.MethodHead, location, nil, nil)
+	if got := w.Header().Get("Upload-Offset"); got != "5" {
+		t.Errorf("HEAD after interrupted PATCH: Upload-Offset %q, want 5", got)
+	}
+
+	// Resuming from the kept offset is accepted
+	patch["Upload-Offset"] = "5"
+	w = tusRequest(r, http.MethodPatch, location, strings.NewReader("wor"), patch)
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("resumed PATCH: status %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestTusPatchDiscardsUnverifiableBytes(t *testing.T) {
+	r := tusRouter(t)
+	location := createTusUpload(t, r, "10")
+
+	sum := sha1.Sum([]byte("helloworld"))
+	w := tusRequest(r, http.MethodPatch, location, brokenBody("hello"), map[string]string{
+		"Content-Type":    "application/offset+octet-stream",
+		"Upload-Offset":   "0",
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("interrupted PATCH: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = tusRequest(r, http.MethodHead, location, nil, nil)
+	if got := w.Header().Get("Upload-Offset"); got != "0" {
+		t.Errorf("HEAD after interrupted checksummed PATCH: Upload-Offset %q, want 0", got)
+	}
+}
+
+// testVideo is a source the upload check accepts: a one second clip when
+// ffmpeg is installed, otherwise an MP4 header, as without ffprobe only the
+// file type is checked
+func testVideo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffprobe is installed without ffmpeg to make a test clip")
+	}
+	out := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "testsrc=duration=1:size=320x240:rate=10",
+		"-pix_fmt", "yuv420p", out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTusPatchRetriesFailedCompletion(t *testing.T) {
+	dbtest.Open(t)
+	r := tusRouter(t)
+	ctx := context.Background()
+	source := testVideo(t)
+	length := strconv.Itoa(len(source))
+
+	w := tusRequest(r, http.MethodPost, "/files", nil, map[string]string{"Upload-Length": length})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	location, videoID := "/files/"+path.Base(w.Header().Get("Location")), w.Header().Get("X-Video-ID")
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+
+	restore := breakJobQueue(t)
+	w = tusRequest(r, http.MethodPatch, location, strings.NewReader(source), patch)
+	restore()
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("final PATCH without a job queue: status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if _, err := videos.Get(ctx, videoID); err != nil {
+		t.Fatalf("video of the failed completion: %v", err)
+	}
+	w = tusRequest(r, http.MethodHead, location, nil, nil)
+	if got := w.Header().Get("Upload-Offset"); got != length {
+		t.Fatalf("HEAD after failed completion: Upload-Offset %q, want %s", got, length)
+	}
+
+	// An empty PATCH at the full offset completes the upload again
+	patch["Upload-Offset"] = length
+	w = tusRequest(r, http.MethodPatch, location, nil, patch)
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != length {
+		t.Fatalf("retried PATCH: status %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	status, err := jobs.LatestStatus(ctx, videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != jobs.StateQueued || countJobs(t, videoID) != 1 {
+		t.Errorf("after retry: job %s, %d jobs; want one queued job", status.State, countJobs(t, videoID))
+	}
+
+	// A completed upload is cleaned up
+	if w := tusRequest(r, http.MethodHead, location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after completion: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -8,7 +8,11 @@ import (
 	"path/filepath"
 	"time"
 
-	"cloud.google.com/go/storage"
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -26,10 +30,8 @@ import (
 // )
 
 const (
-	bucketName      = "packetized-media-bucket"
-	credentialsFile = "service-account.json"
-	localStorage    = "./videos"
-	maxFileSize     = 2 * 1024 * 1024 * 1024 // 2GB in bytes
+	localStorage = "./videos"
+	maxFileSize  = 2 * 1024 * 1024 * 1024 // 2GB in bytes
 )
 
 // // Upload video endpoint
@@ -121,24 +123,17 @@ func UploadVideo(c *gin.Context) {
 		return
 	}
 
+	// Encoding profile, the default one unless the form names another
+	profile, ok := profileFromRequest(c, c.PostForm("profile"))
+	if !ok {
+		return
+	}
+
 	// Generate a unique filename
 	videoID := uuid.New().String()
 	fileExt := filepath.Ext(fileHeader.Filename)
 	newFileName := videoID + fileExt
 
-	// Initalize GCS Client
-	ctx := c.Request.Context()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create GCS client"})
-		return
-	}
-	defer client.Close()
-
-	// Upload the Video to GCS Bucket
-	objectPath := fmt.Sprintf("videos/%s/%s", videoID, newFileName)
-	object := client.Bucket(bucketName).Object(objectPath)
-
 	// Open the uploaded file
 	src, err := fileHeader.Open()
 	if err != nil {
@@ -147,51 +142,105 @@ func UploadVideo(c *gin.Context) {
 	}
 	defer src.Close()
 
-	// Create a Writer to GCS
-	wc := object.NewWriter(ctx)
-	defer wc.Close()
+	// Reject files that are not video before storing them
+	contentType, err := sniffVideo(src)
+	if err != nil {
+		respondUploadError(c, err, "Failed to read uploaded file")
+		return
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
 
-	wc.ContentType = getContentType(newFileName)
+	// Upload the Video to the object store
+	ctx := c.Request.Context()
+	objectPath := fmt.Sprintf("videos/%s/%s", videoID, newFileName)
+	if err := objectstore.Default.Put(ctx, objectPath, src, contentType); err != nil {
+		fmt.Printf("Failed to upload %s: %v\n", objectPath, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file to storage"})
+		return
+	}
 
-	if _, err := io.Copy(wc, src); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file to GCS"})
+	// Probe it, rejecting sources the encoder cannot use
+	_, meta, err := checkUpload(ctx, objectPath)
+	if err != nil {
+		respondUploadError(c, err, "Failed to check uploaded file")
 		return
 	}
 
-	// // Wait for the object to be fully uploaded before processing the video
-	// if !waitForObject(client, bucketName, objectPath, 10, 10*time.Second) {
-	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "File upload not completed"})
-	// 	return
-	// }
+	// Record the video
+	video := &videos.Video{
+		ID:           videoID,
+		FileName:     fileHeader.Filename,
+		SourceObject: objectPath,
+		Size:         fileHeader.Size,
+		ContentType:  contentType,
+		Profile:      profile.Name,
+	}
+	if err := videos.Create(ctx, video); err != nil {
+		fmt.Printf("Failed to record video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record video"})
+		return
+	}
+	recordMediaInfo(ctx, videoID, meta)
+
+	events.Publish(events.UploadReceived, videoID, map[string]interface{}{"filename": fileHeader.Filename, "size": fileHeader.Size})
 
-	// Start encoding in the background
-	go processVideoFromGCS(videoID, bucketName, newFileName)
+	// Queue the video for encoding
+	if _, err := jobs.Enqueue(ctx, videoID, newFileName, profile.Name); err != nil {
+		fmt.Printf("Failed to queue encoding job for %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue video for encoding"})
+		return
+	}
 
 	// Return the video URL
-	videoURL := fmt.Sprintf("https://storage.googleapis.com/packetized-media-bucket/videos/%s/DASH/manifest.mpd", videoID)
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "File uploaded successfully",
 		"video_id":  videoID,
-		"video_url": videoURL,
+		"video_url": manifestURL(ctx, videoID, profile.Name),
 	})
 }
 
-// waitForObject checks if the object exists in GCS and retries a few times before giving up
-func waitForObject(client *storage.Client, bucketName, objectPath string, maxRetries int, delay time.Duration) bool {
-	ctx := context.Background()
-	obj := client.Bucket(bucketName).Object(objectPath)
+// manifestURL signs the DASH manifest the video will have once encoded
+// with the named profile.
+func manifestURL(ctx context.Context, videoID, profileName string) string {
+	profile, err := lookupProfile(profileName)
+	if err != nil {
+		return ""
+	}
+
+	var objectPath string
+	for _, f := range manifests(profile.Packaging, videoID) {
+		if f.Name == "DASH" {
+			objectPath = f.Manifest
+		}
+	}
+	url, err := objectstore.Default.SignedURL(ctx, objectPath, 1*time.Hour)
+	if err != nil {
+		fmt.Printf("Failed to sign manifest URL for %s: %v\n", videoID, err)
+		return ""
+	}
+	return url
+}
 
+// waitForObject checks if the object exists in storage and retries a few times before giving up
+func waitForObject(ctx context.Context, objectPath string, maxRetries int, delay time.Duration) bool {
 	// Retry logic
 	for i := 0; i < maxRetries; i++ {
-		_, err := obj.Attrs(ctx)
+		_, err := objectstore.Default.Stat(ctx, objectPath)
 		if err == nil {
 			// Object exists, proceed with the process
 			return true
 		}
-		if err == storage.ErrObjectNotExist {
+		if err == objectstore.ErrNotExist {
 			// Object does not exist, wait and try again
 			fmt.Printf("Object %s not found, retrying...\n", objectPath)
-			time.Sleep(delay)
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(delay):
+			}
 		} else {
 			// Some other error occurred
 			fmt.Printf("Error retrieving object attributes: %v\n", err)
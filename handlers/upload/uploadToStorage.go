@@ -0,0 +1,69 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"packetized-media-streaming/handlers/objectstore"
+)
+
+// Upload encoded video to the configured object store. onUpload, if not nil,
+// is called after each file is stored. Cancelling ctx, as losing the job's
+// lease does, stops the upload.
+func UploadToStorage(ctx context.Context, folderPath, videoID, format string, onUpload func(objectPath string)) error {
+	//upload each file in the folder
+	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Destination in the object store, keeping subfolders
+		rel, err := filepath.Rel(folderPath, path)
+		if err != nil {
+			return err
+		}
+		objectPath := fmt.Sprintf("videos/%s/%s/%s", videoID, format, filepath.ToSlash(rel))
+
+		// Open file
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// upload to the object store
+		if err := objectstore.Default.Put(ctx, objectPath, file, objectstore.ContentType(objectPath)); err != nil {
+			return err
+		}
+
+		fmt.Printf("Uploaded %s to storage\n", objectPath)
+		if onUpload != nil {
+			onUpload(objectPath)
+		}
+		return nil
+	})
+
+	os.RemoveAll(folderPath)
+
+	return err
+}
+
+// countFiles returns the number of regular files below folderPath
+func countFiles(folderPath string) int {
+	count := 0
+	filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,213 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers/objectstore"
+	"packetized-media-streaming/handlers/videos"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+// probeTimeout bounds the ffprobe run that checks an upload
+const probeTimeout = 2 * time.Minute
+
+// Upload limits, set by InitUploadLimits
+var (
+	maxLongSide, maxShortSide = 3840, 2160
+	maxDuration               = 6 * time.Hour
+)
+
+// videoCodecs are the source codecs the encoder is expected to decode. Any
+// bit depth and chroma subsampling is accepted, as the encoder converts
+// every source to 8-bit 4:2:0 (see codecArgs).
+var videoCodecs = map[string]bool{
+	"h264": true, "hevc": true, "av1": true, "vp8": true, "vp9": true,
+	"mpeg4": true, "mpeg2video": true, "mpeg1video": true, "h263": true,
+	"prores": true, "dnxhd": true, "mjpeg": true, "theora": true,
+	"vc1": true, "wmv3": true, "msmpeg4v3": true, "dvvideo": true,
+}
+
+// uploadError rejects an upload with a client error status and a stable code
+type uploadError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+func rejectUpload(status int, code, format string, args ...interface{}) error {
+	return &uploadError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// InitUploadLimits reads MAX_UPLOAD_RESOLUTION (WIDTHxHEIGHT, applied to
+// portrait video turned sideways) and MAX_UPLOAD_DURATION (a Go duration)
+func InitUploadLimits() error {
+	if value := os.Getenv("MAX_UPLOAD_RESOLUTION"); value != "" {
+		w, h, ok := strings.Cut(value, "x")
+		width, werr := strconv.Atoi(w)
+		height, herr := strconv.Atoi(h)
+		if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 {
+			return fmt.Errorf("MAX_UPLOAD_RESOLUTION must look like 3840x2160, got %q", value)
+		}
+		maxLongSide, maxShortSide = max(width, height), min(width, height)
+	}
+	if value := os.Getenv("MAX_UPLOAD_DURATION"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("MAX_UPLOAD_DURATION must be a positive duration such as 2h, got %q", value)
+		}
+		maxDuration = d
+	}
+	return nil
+}
+
+// sniffVideo detects the real type of an upload from its first bytes,
+// whatever its file name says, and rejects anything that is not video
+func sniffVideo(r io.Reader) (string, error) {
+	mtype, err := mimetype.DetectReader(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if !strings.HasPrefix(mtype.String(), "video/") {
+		return "", rejectUpload(http.StatusUnsupportedMediaType, "unsupported_file_type",
+			"Uploaded file is %s, not a video", mtype.String())
+	}
+	return mtype.String(), nil
+}
+
+// sniffObject runs sniffVideo on a stored object
+func sniffObject(ctx context.Context, objectPath string) (string, error) {
+	rc, err := objectstore.Default.Get(ctx, objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return sniffVideo(rc)
+}
+
+// probeUpload probes a stored upload and rejects sources the encoder cannot
+// use. Without ffprobe installed the checks are skipped and nil is returned.
+func probeUpload(ctx context.Context, objectPath string) (*VideoMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	input, err := probeInput(ctx, objectPath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ProbeVideo(ctx, input)
+	if errors.Is(err, exec.ErrNotFound) {
+		fmt.Printf("Warning: ffprobe is not installed, %s was not checked\n", objectPath)
+		return nil, nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, rejectUpload(http.StatusUnprocessableEntity, "unreadable_media", "Uploaded file could not be read as media")
+	}
+	if err := checkSource(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// checkSource rejects a probed source the encoder cannot use
+func checkSource(meta *VideoMetadata) error {
+	video := meta.VideoStream()
+	if video == nil {
+		return rejectUpload(http.StatusUnprocessableEntity, "no_video_stream", "Uploaded file has no video stream")
+	}
+	if !videoCodecs[video.CodecName] {
+		return rejectUpload(http.StatusUnsupportedMediaType, "unsupported_codec",
+			"Video codec %q is not supported", video.CodecName)
+	}
+
+	duration := meta.Duration()
+	if duration <= 0 {
+		return rejectUpload(http.StatusUnprocessableEntity, "zero_duration", "Uploaded video has no duration")
+	}
+	if time.Duration(duration*float64(time.Second)) > maxDuration {
+		return rejectUpload(http.StatusUnprocessableEntity, "duration_too_long",
+			"Video is %.0f seconds long, the limit is %.0f", duration, maxDuration.Seconds())
+	}
+
+	width, height := video.FrameSize()
+	if max(width, height) > maxLongSide || min(width, height) > maxShortSide {
+		return rejectUpload(http.StatusUnprocessableEntity, "resolution_too_large",
+			"Video is %dx%d, the limit is %dx%d", width, height, maxLongSide, maxShortSide)
+	}
+	return nil
+}
+
+// probeInput is what ffprobe reads a stored object from: the file itself
+// for local storage, otherwise a short-lived signed URL so only the parts
+// ffprobe needs are fetched
+func probeInput(ctx context.Context, objectPath string) (string, error) {
+	if local, ok := objectstore.Default.(*objectstore.Local); ok {
+		f, err := local.Open(objectPath)
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		return f.Name(), nil
+	}
+	return objectstore.Default.SignedURL(ctx, objectPath, probeTimeout)
+}
+
+// respondUploadError writes the response for a failed upload check: the
+// rejection for an uploadError, otherwise a 500 with fallback as message
+func respondUploadError(c *gin.Context, err error, fallback string) {
+	var rejected *uploadError
+	if errors.As(err, &rejected) {
+		c.JSON(rejected.status, gin.H{"error": rejected.message, "code": rejected.code})
+		return
+	}
+	fmt.Printf("%s: %v\n", fallback, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
+
+// checkUpload sniffs and probes an assembled upload, deleting the object
+// when it is rejected. meta is nil when ffprobe is not installed.
+func checkUpload(ctx context.Context, objectPath string) (contentType string, meta *VideoMetadata, err error) {
+	contentType, err = sniffObject(ctx, objectPath)
+	if err == nil {
+		meta, err = probeUpload(ctx, objectPath)
+	}
+
+	var rejected *uploadError
+	if errors.As(err, &rejected) {
+		if err := objectstore.Default.Delete(ctx, objectPath); err != nil {
+			fmt.Printf("Warning: failed to delete rejected upload %s: %v\n", objectPath, err)
+		}
+	}
+	return contentType, meta, err
+}
+
+// recordMediaInfo stores what the upload check probed, so media info is
+// available before encoding starts
+func recordMediaInfo(ctx context.Context, videoID string, meta *VideoMetadata) {
+	if meta == nil {
+		return
+	}
+	if err := videos.SetMediaInfo(ctx, videoID, meta.MediaInfo()); err != nil {
+		fmt.Printf("Warning: failed to record media info of video %s: %v\n", videoID, err)
+	}
+	if err := videos.SetDuration(ctx, videoID, meta.Duration()); err != nil {
+		fmt.Printf("Warning: failed to record duration of video %s: %v\n", videoID, err)
+	}
+}
@@ -0,0 +1,104 @@
+package upload
+
+import (
+	"errors"
+	"testing"
+)
+
+// sourceWith is a minute-long source with one video stream
+func sourceWith(video StreamMetadata) *VideoMetadata {
+	video.CodecType = "video"
+	return &VideoMetadata{
+		Format: FormatMetadata{Duration: "60.000000"},
+		Streams: []StreamMetadata{
+			video,
+			{Index: 1, CodecType: "audio", CodecName: "aac", Channels: 2},
+		},
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		name string
+		meta *VideoMetadata
+		code string
+	}{
+		{
+			name: "8-bit h264",
+			meta: sourceWith(StreamMetadata{CodecName: "h264", Width: 1920, Height: 1080, PixFmt: "yuv420p", AvgFrameRate: "30/1"}),
+		},
+		{
+			name: "10-bit hdr hevc",
+			meta: sourceWith(StreamMetadata{CodecName: "hevc", Profile: "Main 10", Width: 3840, Height: 2160,
+				PixFmt: "yuv420p10le", ColorTransfer: "smpte2084", AvgFrameRate: "24000/1001"}),
+		},
+		{
+			name: "10-bit 4:2:2 prores",
+			meta: sourceWith(StreamMetadata{CodecName: "prores", Profile: "HQ", Width: 1920, Height: 1080,
+				PixFmt: "yuv422p10le", BitsPerRawSample: "10", AvgFrameRate: "25/1"}),
+		},
+		{
+			name: "unsupported codec",
+			meta: sourceWith(StreamMetadata{CodecName: "cinepak", Width: 320, Height: 240}),
+			code: "unsupported_codec",
+		},
+		{
+			name: "too large",
+			meta: sourceWith(StreamMetadata{CodecName: "h264", Width: 7680, Height: 4320, PixFmt: "yuv420p"}),
+			code: "resolution_too_large",
+		},
+		{
+			name: "no duration",
+			meta: &VideoMetadata{Streams: []StreamMetadata{{CodecType: "video", CodecName: "h264", Width: 640, Height: 360}}},
+			code: "zero_duration",
+		},
+		{
+			name: "audio only",
+			meta: &VideoMetadata{Format: FormatMetadata{Duration: "60"}, Streams: []StreamMetadata{{CodecType: "audio", CodecName: "mp3"}}},
+			code: "no_video_stream",
+		},
+	}
+	for _, tt := range tests {
+		err := checkSource(tt.meta)
+		var rejected *uploadError
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("%s: rejected: %v", tt.name, err)
+		case tt.code != "" && (!errors.As(err, &rejected) || rejected.code != tt.code):
+			t.Errorf("%s: error %v, want code %s", tt.name, err, tt.code)
+		}
+	}
+}
+
+// A 10-bit source is accepted because every encode converts it to the
+// 8-bit 4:2:0 the H.264 profiles and CODECS strings describe
+func TestHighBitDepthEncodesTo8Bit(t *testing.T) {
+	if err := InitProfiles(); err != nil {
+		t.Fatal(err)
+	}
+	meta := sourceWith(StreamMetadata{CodecName: "hevc", Profile: "Main 10", Width: 1920, Height: 1080,
+		PixFmt: "yuv420p10le", AvgFrameRate: "30/1"})
+	if depth := meta.VideoStream().bitDepth(); depth != 10 {
+		t.Fatalf("bit depth %d, want 10", depth)
+	}
+
+	for name, p := range profiles {
+		p := *p
+		p.TrickPlay = true
+		for _, pass := range p.forSource(meta).encodePasses("in.mkv", "v1") {
+			encoders := 0
+			for i, arg := range pass.args {
+				if arg != "libx264" {
+					continue
+				}
+				encoders++
+				if i+2 >= len(pass.args) || pass.args[i+1] != "-pix_fmt" || pass.args[i+2] != "yuv420p" {
+					t.Errorf("%s %s: libx264 output without -pix_fmt yuv420p", name, pass.format)
+				}
+			}
+			if encoders == 0 {
+				t.Errorf("%s %s: no libx264 output", name, pass.format)
+			}
+		}
+	}
+}
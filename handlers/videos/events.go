@@ -0,0 +1,59 @@
+package videos
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamVideoEvents streams a video's state changes and progress as
+// Server-Sent Events. The first event is a "status" snapshot; the stream
+// ends after video.ready or video.failed.
+func StreamVideoEvents(c *gin.Context) {
+	videoID := c.Param("videoID")
+
+	// Subscribe before reading the status so no event falls in between
+	ch, unsubscribe := events.Subscribe(videoID)
+	defer unsubscribe()
+
+	status, err := jobs.LatestStatus(c.Request.Context(), videoID)
+	if err == jobs.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video status"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("status", status)
+	c.Writer.Flush()
+
+	if status.State == jobs.StateSucceeded || status.State == jobs.StateFailed {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			// SSE comment line, keeps proxies from closing an idle stream
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case e := <-ch:
+			c.SSEvent(e.Type, e)
+			return !e.Terminal()
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package videos
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMediaInfo returns the streams, codecs and container details probed
+// from a video's source
+func GetMediaInfo(c *gin.Context) {
+	videoID := c.Param("videoID")
+
+	info, err := LoadMediaInfo(c.Request.Context(), videoID)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to load media info of video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load media info"})
+		return
+	}
+	if info == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Media info is not available yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,26 @@
+package videos
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetVideo returns the stored record of a video
+func GetVideo(c *gin.Context) {
+	videoID := c.Param("videoID")
+
+	video, err := Get(c.Request.Context(), videoID)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to load video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video"})
+		return
+	}
+
+	c.JSON(http.StatusOK, video)
+}
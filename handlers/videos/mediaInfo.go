@@ -0,0 +1,113 @@
+package videos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"packetized-media-streaming/handlers"
+)
+
+// MediaInfo describes the container and tracks of an uploaded source
+type MediaInfo struct {
+	Container Container       `json:"container"`
+	Video     []VideoTrack    `json:"video"`
+	Audio     []AudioTrack    `json:"audio"`
+	Subtitles []SubtitleTrack `json:"subtitles"`
+}
+
+// Container is the format-level part of the media info
+type Container struct {
+	Format     string            `json:"format"`
+	FormatName string            `json:"format_name,omitempty"`
+	Duration   float64           `json:"duration"`
+	BitRate    int64             `json:"bit_rate,omitempty"`
+	Size       int64             `json:"size,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// VideoTrack is a video stream of the source. DisplayWidth and DisplayHeight
+// are the size it is shown at, after the sample aspect ratio and rotation.
+type VideoTrack struct {
+	Index              int     `json:"index"`
+	Codec              string  `json:"codec"`
+	Profile            string  `json:"profile,omitempty"`
+	Level              int     `json:"level,omitempty"`
+	Width              int     `json:"width"`
+	Height             int     `json:"height"`
+	DisplayWidth       int     `json:"display_width"`
+	DisplayHeight      int     `json:"display_height"`
+	SampleAspectRatio  string  `json:"sample_aspect_ratio,omitempty"`
+	DisplayAspectRatio string  `json:"display_aspect_ratio,omitempty"`
+	FrameRate          float64 `json:"frame_rate,omitempty"`
+	BitRate            int64   `json:"bit_rate,omitempty"`
+	PixelFormat        string  `json:"pixel_format,omitempty"`
+	BitDepth           int     `json:"bit_depth,omitempty"`
+	ColorRange         string  `json:"color_range,omitempty"`
+	ColorSpace         string  `json:"color_space,omitempty"`
+	ColorTransfer      string  `json:"color_transfer,omitempty"`
+	ColorPrimaries     string  `json:"color_primaries,omitempty"`
+	// HDR is PQ, HLG or Dolby Vision, empty for SDR
+	HDR      string `json:"hdr,omitempty"`
+	Rotation int    `json:"rotation,omitempty"`
+	Language string `json:"language,omitempty"`
+	Default  bool   `json:"default"`
+}
+
+// AudioTrack is an audio stream of the source
+type AudioTrack struct {
+	Index         int    `json:"index"`
+	Codec         string `json:"codec"`
+	Profile       string `json:"profile,omitempty"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	SampleRate    int    `json:"sample_rate"`
+	BitRate       int64  `json:"bit_rate,omitempty"`
+	Language      string `json:"language,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Default       bool   `json:"default"`
+}
+
+// SubtitleTrack is a subtitle stream of the source
+type SubtitleTrack struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+}
+
+// SetMediaInfo records the probed media info of a video's source
+func SetMediaInfo(ctx context.Context, id string, info *MediaInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE videos SET media_info = ?, updated_at = ? WHERE id = ?`,
+		string(data), time.Now().UTC(), id)
+	return err
+}
+
+// LoadMediaInfo returns the media info of a video, nil if its source has not
+// been probed yet
+func LoadMediaInfo(ctx context.Context, id string) (*MediaInfo, error) {
+	var data sql.NullString
+	err := handlers.CloudSQLDB.QueryRowContext(ctx, `SELECT media_info FROM videos WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil || !data.Valid {
+		return nil, err
+	}
+
+	var info MediaInfo
+	if err := json.Unmarshal([]byte(data.String), &info); err != nil {
+		return nil, fmt.Errorf("decoding media info of video %s: %w", id, err)
+	}
+	return &info, nil
+}
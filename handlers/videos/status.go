@@ -0,0 +1,29 @@
+package videos
+
+import (
+	"fmt"
+	"net/http"
+
+	"packetized-media-streaming/handlers/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetVideoStatus reports whether a video's encoding is queued, running,
+// failed or done, with the current step and percent complete
+func GetVideoStatus(c *gin.Context) {
+	videoID := c.Param("videoID")
+
+	status, err := jobs.LatestStatus(c.Request.Context(), videoID)
+	if err == jobs.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to load status of video %s: %v\n", videoID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load video status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,197 @@
+package videos
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/events"
+	"packetized-media-streaming/handlers/jobs"
+)
+
+// Video states
+const (
+	StatusUploaded   = "uploaded"
+	StatusQueued     = "queued"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+// Video is a row of the videos table
+type Video struct {
+	ID           string      `json:"id"`
+	FileName     string      `json:"filename"`
+	SourceObject string      `json:"source_object"`
+	Size         int64       `json:"size"`
+	ContentType  string      `json:"content_type"`
+	Profile      string      `json:"profile"`
+	Duration     float64     `json:"duration,omitempty"`
+	Status       string      `json:"status"`
+	Error        string      `json:"error,omitempty"`
+	Formats      []Format    `json:"formats"`
+	Renditions   []Rendition `json:"renditions"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	ReadyAt      *time.Time  `json:"ready_at,omitempty"`
+}
+
+// Format is a packaging of the video and the object key of its manifest
+type Format struct {
+	Name     string `json:"name"`
+	Manifest string `json:"manifest"`
+}
+
+// Rendition is one encoded quality of a format
+type Rendition struct {
+	Format    string `json:"format"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Bandwidth int    `json:"bandwidth"`
+}
+
+// ErrNotFound is returned for an unknown video ID
+var ErrNotFound = errors.New("video not found")
+
+// Create stores a newly uploaded video. Creating it again, as a retried
+// upload completion does, resets the existing record.
+func Create(ctx context.Context, v *Video) error {
+	now := time.Now().UTC()
+	v.Status, v.CreatedAt, v.UpdatedAt = StatusUploaded, now, now
+
+	var n int
+	if err := handlers.CloudSQLDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM videos WHERE id = ?`, v.ID).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		_, err := handlers.CloudSQLDB.ExecContext(ctx,
+			`UPDATE videos SET filename = ?, source_object = ?, size = ?, content_type = ?, profile = ?, status = ?, error = NULL, updated_at = ?
+			WHERE id = ?`,
+			v.FileName, v.SourceObject, v.Size, v.ContentType, v.Profile, v.Status, now, v.ID)
+		return err
+	}
+
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`INSERT INTO videos (id, filename, source_object, size, content_type, profile, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.ID, v.FileName, v.SourceObject, v.Size, v.ContentType, v.Profile, v.Status, now, now)
+	return err
+}
+
+// Get loads a video by ID
+func Get(ctx context.Context, id string) (*Video, error) {
+	var v Video
+	var duration sql.NullFloat64
+	var profile, message, formats, renditions sql.NullString
+	var readyAt sql.NullTime
+	err := handlers.CloudSQLDB.QueryRowContext(ctx,
+		`SELECT id, filename, source_object, size, content_type, profile, duration, status, error, formats, renditions, created_at, updated_at, ready_at
+		FROM videos WHERE id = ?`, id).
+		Scan(&v.ID, &v.FileName, &v.SourceObject, &v.Size, &v.ContentType, &profile, &duration, &v.Status, &message,
+			&formats, &renditions, &v.CreatedAt, &v.UpdatedAt, &readyAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v.Profile = profile.String
+	v.Duration = duration.Float64
+	v.Error = message.String
+	if readyAt.Valid {
+		v.ReadyAt = &readyAt.Time
+	}
+	v.Formats, v.Renditions = []Format{}, []Rendition{}
+	if formats.Valid {
+		if err := json.Unmarshal([]byte(formats.String), &v.Formats); err != nil {
+			return nil, fmt.Errorf("decoding formats of video %s: %w", id, err)
+		}
+	}
+	if renditions.Valid {
+		if err := json.Unmarshal([]byte(renditions.String), &v.Renditions); err != nil {
+			return nil, fmt.Errorf("decoding renditions of video %s: %w", id, err)
+		}
+	}
+	return &v, nil
+}
+
+// SetDuration records the source duration in seconds
+func SetDuration(ctx context.Context, id string, duration float64) error {
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE videos SET duration = ?, updated_at = ? WHERE id = ?`,
+		duration, time.Now().UTC(), id)
+	return err
+}
+
+// SetOutputs records the formats and renditions an encode produced
+func SetOutputs(ctx context.Context, id string, formats []Format, renditions []Rendition) error {
+	f, err := json.Marshal(formats)
+	if err != nil {
+		return err
+	}
+	r, err := json.Marshal(renditions)
+	if err != nil {
+		return err
+	}
+	_, err = handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE videos SET formats = ?, renditions = ?, updated_at = ? WHERE id = ?`,
+		string(f), string(r), time.Now().UTC(), id)
+	return err
+}
+
+// setStatus moves a video to status; a ready video records when it became ready
+func setStatus(ctx context.Context, id, status, message string) error {
+	now := time.Now().UTC()
+
+	var msg sql.NullString
+	if message != "" {
+		msg = sql.NullString{String: message, Valid: true}
+	}
+	var readyAt sql.NullTime
+	if status == StatusReady {
+		readyAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE videos SET status = ?, error = ?, ready_at = COALESCE(?, ready_at), updated_at = ? WHERE id = ?`,
+		status, msg, readyAt, now, id)
+	return err
+}
+
+// TrackJobs keeps each video's status in step with its encoding job
+func TrackJobs() {
+	events.Listen(func(e events.Event) {
+		var status, message string
+		switch e.Type {
+		case events.StateChanged:
+			switch e.Data["state"] {
+			case jobs.StateQueued:
+				status = StatusQueued
+			case jobs.StateRunning:
+				status = StatusProcessing
+			default:
+				return
+			}
+			if _, ok := e.Data["step"]; ok {
+				// Step changes within a running job
+				return
+			}
+		case events.VideoReady:
+			status = StatusReady
+		case events.VideoFailed:
+			status = StatusFailed
+			message, _ = e.Data["error"].(string)
+		default:
+			return
+		}
+
+		if err := setStatus(context.Background(), e.VideoID, status, message); err != nil {
+			fmt.Printf("Failed to set status of video %s to %s: %v\n", e.VideoID, status, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"packetized-media-streaming/handlers/events"
+)
+
+// Webhook event names, mapped from the internal events they are fired on
+var webhookEvents = map[string]string{
+	events.UploadReceived:  "video.uploaded",
+	events.EncodingStarted: "video.encoding.started",
+	events.VideoReady:      "video.ready",
+	events.VideoFailed:     "video.failed",
+}
+
+const (
+	maxAttempts  = 8
+	firstBackoff = 30 * time.Second
+	maxBackoff   = time.Hour
+	pollEvery    = 5 * time.Second
+	claimLease   = time.Minute
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Dispatcher records webhook deliveries for lifecycle events and sends them,
+// retrying failures with exponential backoff
+type Dispatcher struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	wake   chan struct{}
+}
+
+// Start begins listening for events and delivering callbacks
+func Start() *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{cancel: cancel, wake: make(chan struct{}, 1)}
+
+	events.Listen(d.record)
+
+	d.wg.Add(1)
+	go d.run(ctx)
+	return d
+}
+
+// Stop waits for in-flight deliveries; pending ones are sent after restart
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// record stores a pending delivery for each webhook subscribed to the event
+func (d *Dispatcher) record(e events.Event) {
+	name, ok := webhookEvents[e.Type]
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      name,
+		"video_id":   e.VideoID,
+		"data":       e.Data,
+		"created_at": e.Time,
+	})
+	if err != nil {
+		fmt.Printf("Failed to encode %s webhook payload: %v\n", name, err)
+		return
+	}
+
+	ctx := context.Background()
+	hooks, err := listWebhooks(ctx)
+	if err != nil {
+		fmt.Printf("Failed to load webhooks for %s: %v\n", name, err)
+		return
+	}
+
+	for _, w := range hooks {
+		if !w.subscribes(name) {
+			continue
+		}
+		if err := insertDelivery(ctx, w.ID, name, e.VideoID, string(payload)); err != nil {
+			fmt.Printf("Failed to record %s delivery for webhook %d: %v\n", name, w.ID, err)
+		}
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		due, err := claimDue(ctx, 20, claimLease)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Failed to load due webhook deliveries: %v\n", err)
+		}
+		for i := range due {
+			d.deliver(ctx, &due[i])
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(pollEvery):
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) {
+	statusCode, err := send(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down; the claim expires and the delivery is retried later
+		return
+	}
+
+	var next *time.Time
+	attempt := delivery.Attempts + 1
+	if err != nil && attempt < maxAttempts {
+		at := time.Now().UTC().Add(backoff(attempt))
+		next = &at
+	}
+
+	if err != nil {
+		fmt.Printf("Webhook delivery %d (%s) attempt %d failed: %v\n", delivery.ID, delivery.Event, attempt, err)
+	}
+	if err := recordAttempt(context.Background(), delivery, statusCode, err, next); err != nil {
+		fmt.Printf("Failed to record webhook delivery %d: %v\n", delivery.ID, err)
+	}
+}
+
+// send POSTs the signed payload; any non-2xx response is a failure
+func send(ctx context.Context, delivery *Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.url, bytes.NewReader([]byte(delivery.payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "packetized-media-streaming-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(delivery.secret, timestamp, []byte(delivery.payload)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" with the webhook
+// secret. Receivers recompute it to verify X-Webhook-Signature and should
+// reject stale timestamps to prevent replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the delay after every failed attempt, up to maxBackoff
+func backoff(attempt int) time.Duration {
+	delay := firstBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// CreateWebhook registers an endpoint. The signing secret is only returned here.
+func CreateWebhook(c *gin.Context) {
+	var req createWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http or https URL"})
+		return
+	}
+
+	for _, e := range req.Events {
+		if !knownEvent(e) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown event %q", e)})
+			return
+		}
+	}
+
+	if req.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+		req.Secret = hex.EncodeToString(buf)
+	}
+
+	w := Webhook{URL: req.URL, Events: req.Events, Secret: req.Secret}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	if err := createWebhook(c.Request.Context(), &w); err != nil {
+		fmt.Printf("Failed to create webhook: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, w)
+}
+
+// ListWebhooks returns the registered endpoints without their secrets
+func ListWebhooks(c *gin.Context) {
+	hooks, err := listWebhooks(c.Request.Context())
+	if err != nil {
+		fmt.Printf("Failed to list webhooks: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhooks"})
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// DeleteWebhook stops deliveries to an endpoint; its delivery log is kept
+func DeleteWebhook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("webhookID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	err = deleteWebhook(c.Request.Context(), id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to delete webhook %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries returns a webhook's delivery log, newest first.
+// Optional query parameters: state (pending, delivered, failed) and limit.
+func ListDeliveries(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("webhookID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	state := c.Query("state")
+	if state != "" && state != DeliveryPending && state != DeliveryDelivered && state != DeliveryFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be pending, delivered or failed"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if ok, err := webhookExists(ctx, id); err != nil {
+		fmt.Printf("Failed to load webhook %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load webhook"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	deliveries, err := listDeliveries(ctx, id, state, limit)
+	if err != nil {
+		fmt.Printf("Failed to list deliveries of webhook %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+func knownEvent(name string) bool {
+	for _, e := range webhookEvents {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
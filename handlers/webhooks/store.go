@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"packetized-media-streaming/handlers"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a registered endpoint. Events lists the subscribed event names;
+// an empty list subscribes to all of them.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is one callback to one webhook, with the outcome of its latest attempt
+type Delivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	Event         string     `json:"event"`
+	VideoID       string     `json:"video_id"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	StatusCode    int        `json:"last_status_code,omitempty"`
+	Error         string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	payload string
+	secret  string
+	url     string
+}
+
+// ErrNotFound is returned for an unknown webhook ID
+var ErrNotFound = errors.New("webhook not found")
+
+func createWebhook(ctx context.Context, w *Webhook) error {
+	w.CreatedAt = time.Now().UTC()
+	res, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`INSERT INTO webhooks (url, secret, events, active, created_at) VALUES (?, ?, ?, TRUE, ?)`,
+		w.URL, w.Secret, strings.Join(w.Events, ","), w.CreatedAt)
+	if err != nil {
+		return err
+	}
+	w.ID, err = res.LastInsertId()
+	return err
+}
+
+// listWebhooks returns active webhooks, with their secrets
+func listWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := handlers.CloudSQLDB.QueryContext(ctx,
+		`SELECT id, url, secret, events, created_at FROM webhooks WHERE active = TRUE ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var list string
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &list, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = []string{}
+		if list != "" {
+			w.Events = strings.Split(list, ",")
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func deleteWebhook(ctx context.Context, id int64) error {
+	res, err := handlers.CloudSQLDB.ExecContext(ctx, `UPDATE webhooks SET active = FALSE WHERE id = ? AND active = TRUE`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// subscribes reports whether the webhook wants the event
+func (w *Webhook) subscribes(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func insertDelivery(ctx context.Context, webhookID int64, event, videoID, payload string) error {
+	now := time.Now().UTC()
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event, video_id, payload, state, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		webhookID, event, videoID, payload, DeliveryPending, now, now, now)
+	return err
+}
+
+// claimDue leases up to limit pending deliveries whose next attempt is due.
+// The lease is pushing next_attempt_at forward, so another instance polling
+// at the same time skips them.
+func claimDue(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	now := time.Now().UTC()
+	rows, err := handlers.CloudSQLDB.QueryContext(ctx,
+		`SELECT d.id, d.webhook_id, d.event, d.video_id, d.payload, d.attempts, d.next_attempt_at, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.state = ? AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at LIMIT ?`,
+		DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []Delivery
+	for rows.Next() {
+		var d Delivery
+		var next time.Time
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.VideoID, &d.payload, &d.Attempts, &next, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.NextAttemptAt = &next
+		candidates = append(candidates, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []Delivery
+	for _, d := range candidates {
+		res, err := handlers.CloudSQLDB.ExecContext(ctx,
+			`UPDATE webhook_deliveries SET next_attempt_at = ?, updated_at = ? WHERE id = ? AND state = ? AND next_attempt_at = ?`,
+			now.Add(lease), now, d.ID, DeliveryPending, *d.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+// recordAttempt stores the outcome of a delivery attempt. A nil next means
+// no further attempts: delivered on success, failed otherwise.
+func recordAttempt(ctx context.Context, d *Delivery, statusCode int, attemptErr error, next *time.Time) error {
+	now := time.Now().UTC()
+
+	state := DeliveryPending
+	var deliveredAt *time.Time
+	switch {
+	case attemptErr == nil:
+		state, deliveredAt = DeliveryDelivered, &now
+	case next == nil:
+		state = DeliveryFailed
+	}
+
+	var message sql.NullString
+	if attemptErr != nil {
+		message = sql.NullString{String: attemptErr.Error(), Valid: true}
+	}
+	var code sql.NullInt64
+	if statusCode != 0 {
+		code = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	}
+
+	_, err := handlers.CloudSQLDB.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		SET state = ?, attempts = attempts + 1, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ?, updated_at = ?
+		WHERE id = ?`,
+		state, next, code, message, deliveredAt, now, d.ID)
+	return err
+}
+
+// listDeliveries returns a webhook's most recent deliveries, newest first
+func listDeliveries(ctx context.Context, webhookID int64, state string, limit int) ([]Delivery, error) {
+	query := `SELECT id, webhook_id, event, video_id, state, attempts, next_attempt_at, last_status_code, last_error, delivered_at, created_at, updated_at
+		FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []interface{}{webhookID}
+	if state != "" {
+		query += ` AND state = ?`
+		args = append(args, state)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := handlers.CloudSQLDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var next, delivered sql.NullTime
+		var code sql.NullInt64
+		var message sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.VideoID, &d.State, &d.Attempts, &next, &code, &message, &delivered, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if next.Valid {
+			d.NextAttemptAt = &next.Time
+		}
+		if delivered.Valid {
+			d.DeliveredAt = &delivered.Time
+		}
+		d.StatusCode = int(code.Int64)
+		d.Error = message.String
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func webhookExists(ctx context.Context, id int64) (bool, error) {
+	var n int
+	err := handlers.CloudSQLDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhooks WHERE id = ?`, id).Scan(&n)
+	return n > 0, err
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"packetized-media-streaming/handlers"
+	"packetized-media-streaming/handlers/jobs"
+	"packetized-media-streaming/handlers/migrations"
+	"packetized-media-streaming/handlers/objectstore"
 	"packetized-media-streaming/handlers/streaming"
+	"packetized-media-streaming/handlers/subtitles"
 	"packetized-media-streaming/handlers/upload"
+	"packetized-media-streaming/handlers/videos"
+	"packetized-media-streaming/handlers/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +25,16 @@ func main() {
 	// Initialize Database
 	handlers.InitDB()
 
+	// "migrate up|down|status" manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrations.Command(handlers.CloudSQLDB, handlers.Dialect, os.Args[2:])
+		handlers.CloudSQLDB.Close()
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
 	// Ensure proper cleanup on shutdown
 	defer func() {
 		if handlers.CloudSQLDB != nil {
@@ -25,11 +43,40 @@ func main() {
 		}
 	}()
 
-	// Ensure GOOGLE_APPLICATION_CREDENTIALS is set (Optional)
-	credentials := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credentials == "" {
-		fmt.Println("Warning: GOOGLE_APPLICATION_CREDENTIALS is not set")
+	// Initialize object storage (GCS, S3 or local filesystem)
+	if err := objectstore.Init(); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer objectstore.Default.Close()
+
+	// Bring the schema up to date unless AUTO_MIGRATE=false
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		applied, err := migrations.Up(context.Background(), handlers.CloudSQLDB, handlers.Dialect)
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+		}
+	}
+
+	// Load encoding profiles
+	if err := upload.InitProfiles(); err != nil {
+		log.Fatalf("Failed to load encoding profiles: %v", err)
 	}
+	if err := upload.InitUploadLimits(); err != nil {
+		log.Fatalf("Failed to read upload limits: %v", err)
+	}
+
+	// Start webhook delivery before anything can publish lifecycle events
+	dispatcher := webhooks.Start()
+
+	// Keep video records in step with their encoding jobs
+	videos.TrackJobs()
+
+	// Start the encoding workers; unfinished jobs from a previous run are resumed
+	pool := jobs.NewPool(upload.ProcessJob)
+	pool.Start()
 
 	// Setup Gin router
 	r := gin.Default()
@@ -37,8 +84,39 @@ func main() {
 	r.MaxMultipartMemory = 2 << 30 // 500 MB limit  
 
 	// Routes
+	r.GET("/profiles", upload.ListProfiles)
 	r.POST("/upload", upload.UploadVideo)
+	r.POST("/uploads", upload.StartChunkedUpload)
+	r.GET("/uploads/:uploadID", upload.GetChunkedUploadStatus)
+	r.PUT("/uploads/:uploadID/chunks/:index", upload.UploadChunk)
+	r.POST("/uploads/:uploadID/complete", upload.CompleteChunkedUpload)
+
+	// tus 1.0 resumable uploads
+	r.OPTIONS("/files", upload.TusOptions)
+	r.OPTIONS("/files/:uploadID", upload.TusOptions)
+	r.POST("/files", upload.TusCreate)
+	r.HEAD("/files/:uploadID", upload.TusHead)
+	r.PATCH("/files/:uploadID", upload.TusPatch)
+	r.DELETE("/files/:uploadID", upload.TusDelete)
+
 	r.GET("/stream/:videoID", streaming.GetVideoURL)
+	r.GET("/videos/:videoID", videos.GetVideo)
+	r.GET("/videos/:videoID/mediainfo", videos.GetMediaInfo)
+	r.GET("/videos/:videoID/thumbnails", streaming.GetThumbnails)
+	r.POST("/videos/:videoID/subtitles", subtitles.UploadSubtitle)
+	r.GET("/videos/:videoID/subtitles", subtitles.ListSubtitles)
+	r.GET("/videos/:videoID/status", videos.GetVideoStatus)
+	r.GET("/videos/:videoID/events", videos.StreamVideoEvents)
+
+	r.POST("/webhooks", webhooks.CreateWebhook)
+	r.GET("/webhooks", webhooks.ListWebhooks)
+	r.DELETE("/webhooks/:webhookID", webhooks.DeleteWebhook)
+	r.GET("/webhooks/:webhookID/deliveries", webhooks.ListDeliveries)
+
+	// Signed URLs issued by the local storage backend point here
+	if _, ok := objectstore.Default.(*objectstore.Local); ok {
+		r.GET("/objects/:sig/:expires/*key", streaming.ServeObject)
+	}
 
 	// Get PORT from environment variable
 	port := os.Getenv("PORT")
@@ -61,6 +139,8 @@ func main() {
 	<-quit
 
 	fmt.Println("\nShutting down server...")
+	pool.Stop()
+	dispatcher.Stop()
 	handlers.CloudSQLDB.Close()
 	fmt.Println("Server shut down gracefully")
 }